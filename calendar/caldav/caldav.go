@@ -0,0 +1,392 @@
+// Package caldav reads events from, and syncs events to, a calendar collection on a
+// CalDAV server (RFC 4791), e.g. Fastmail or Nextcloud.
+package caldav
+
+import (
+	"bytes"
+	"calsync/calendar"
+	"calsync/calendar/ics"
+	"calsync/config"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	gocal "github.com/apognu/gocal"
+)
+
+// utcLayout is the iCalendar date-time in UTC, as used by time-range filters
+const utcLayout = "20060102T150405Z"
+
+const (
+	reasonInSync     = "already synced"
+	reasonNotManaged = "not calsync managed"
+	reasonChanged    = "changed at source"
+	reasonStale      = "not at source anymore"
+	reasonNew        = "new at source"
+	reasonDeleteAll  = "calsync managed"
+)
+
+const requestTimeout = 30 * time.Second
+
+type Calendar struct {
+	ctx      context.Context
+	cfg      config.CalDAV
+	http     *http.Client
+	password string
+
+	// collection is the calendar collection, discovered on first use
+	collection *url.URL
+	// etags of the resources listed by the last plan, keyed by their URL
+	etags map[string]string
+	// noExpand is set once the server refused to expand recurring events
+	noExpand bool
+}
+
+func New(ctx context.Context, cfg config.CalDAV) (*Calendar, error) {
+	password, err := readPassword(ctx, cfg.PasswordCommand)
+	if err != nil {
+		return nil, fmt.Errorf("getting password of %s: %w", cfg.URL, err)
+	}
+
+	return &Calendar{
+		ctx:      ctx,
+		cfg:      cfg,
+		http:     &http.Client{Timeout: requestTimeout},
+		password: password,
+		etags:    make(map[string]string),
+	}, nil
+}
+
+// readPassword runs command with the shell, the password is its output without trailing newline
+func readPassword(ctx context.Context, command string) (string, error) {
+	if command == "" {
+		return "", nil
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("PasswordCommand failed: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("PasswordCommand failed: %w", err)
+	}
+
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+func (c *Calendar) String() string {
+	if c.cfg.Name != "" {
+		return fmt.Sprintf("CalDAV Calendar: %s", c.cfg.Name)
+	}
+	return fmt.Sprintf("CalDAV Calendar: %s", c.cfg.URL)
+}
+
+// collectionURL returns the calendar collection, discovering it on first use
+func (c *Calendar) collectionURL(ctx context.Context) (*url.URL, error) {
+	if c.collection != nil {
+		return c.collection, nil
+	}
+
+	collection, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Discovered CalDAV calendar collection", "calendar", c.String(), "collection", collection)
+	c.collection = collection
+
+	return c.collection, nil
+}
+
+func (c *Calendar) PutEvents() error {
+	return fmt.Errorf("PutEvents not implemented for CalDAV calendar")
+}
+
+// SyncToDest will sync all events to the CalDAV calendar, like gcal.Client.SyncToDest
+func (c *Calendar) SyncToDest(events []calendar.Event) error {
+	plan, err := c.PlanSync(events)
+	if err != nil {
+		return err
+	}
+	return c.ApplyPlan(plan)
+}
+
+func (c *Calendar) DeleteAll(nDays int) error {
+	plan, err := c.PlanDeleteAll(nDays)
+	if err != nil {
+		return err
+	}
+	return c.ApplyPlan(plan)
+}
+
+// remoteEvent is an event of the collection, as far as planning needs it
+type remoteEvent struct {
+	resource
+	uid     string
+	title   string
+	start   string
+	end     string
+	hash    string
+	source  string
+	managed bool
+}
+
+// getRemoteEvents lists the events of the collection between start and end
+func (c *Calendar) getRemoteEvents(start time.Time, end time.Time) ([]remoteEvent, error) {
+	resources, err := c.listResources(c.ctx, start, end, false)
+	if err != nil {
+		return nil, err
+	}
+
+	c.etags = make(map[string]string, len(resources))
+	events := make([]remoteEvent, 0, len(resources))
+	for _, r := range resources {
+		c.etags[r.href.String()] = r.etag
+
+		event, err := parseResource(r, end)
+		if err != nil {
+			slog.Warn("Ignoring unreadable CalDAV event", "calendar", c.String(), "href", r.href, "error", err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// parseResource reads the first VEVENT of the resource, recurring events are expanded until end.
+// The server already filtered events by time range.
+func parseResource(r resource, end time.Time) (remoteEvent, error) {
+	start := time.Time{}
+	parser := gocal.NewParser(strings.NewReader(r.data))
+	parser.Start, parser.End = &start, &end
+	parser.SkipBounds = true
+	if err := parser.Parse(); err != nil {
+		return remoteEvent{}, err
+	}
+	if len(parser.Events) == 0 {
+		return remoteEvent{}, fmt.Errorf("no VEVENT")
+	}
+
+	e := parser.Events[0]
+	event := remoteEvent{
+		resource: r,
+		uid:      e.Uid,
+		title:    e.Summary,
+		hash:     ics.UnescapeText(e.CustomAttributes[ics.PropHash]),
+		source:   ics.UnescapeText(e.CustomAttributes[ics.PropSource]),
+		managed:  strings.HasSuffix(e.Uid, ics.UIDSuffix),
+	}
+	if e.Start != nil {
+		event.start = e.Start.Format(time.RFC3339)
+	}
+	if e.End != nil {
+		event.end = e.End.Format(time.RFC3339)
+	}
+
+	return event, nil
+}
+
+// resourceURL returns where the event with uid is written in the collection
+func resourceURL(collection *url.URL, uid string) *url.URL {
+	name := strings.TrimSuffix(uid, ics.UIDSuffix) + ".ics"
+	return collection.ResolveReference(&url.URL{Path: name})
+}
+
+// PlanSync computes what SyncToDest would do, without modifying the CalDAV calendar
+func (c *Calendar) PlanSync(events []calendar.Event) (calendar.Plan, error) {
+	plan := calendar.Plan{Calendar: c.String()}
+
+	// Without events there's no range to look at, and nothing must be deleted
+	if len(events) == 0 {
+		slog.Warn("No events to sync, skipping to avoid deleting calsync events", "calendar", c.String())
+		return plan, nil
+	}
+
+	calendar.Events(events).SortStartTime()
+
+	remote, err := c.getRemoteEvents(events[0].Start, events[len(events)-1].Stop)
+	if err != nil {
+		return plan, fmt.Errorf("Getting all events failed: %w", err)
+	}
+
+	slog.Info("Planning sync of all events", "total_local_events", len(events), "total_caldav_events", len(remote))
+
+	local := make(map[string]calendar.Event, len(events))
+	for _, event := range events {
+		local[ics.UID(event)] = event
+	}
+
+	found := make(map[string]bool)
+	for _, r := range remote {
+		if !r.managed {
+			// Manually created event, not via calsync, leave it alone!
+			plan.Changes = append(plan.Changes, remoteChange(calendar.ActionIgnore, r, reasonNotManaged))
+			continue
+		}
+
+		event, ok := local[r.uid]
+		if !ok || found[r.uid] {
+			plan.Changes = append(plan.Changes, remoteChange(calendar.ActionDelete, r, reasonStale))
+			continue
+		}
+		found[r.uid] = true
+
+		if r.hash == event.Hash() {
+			plan.Changes = append(plan.Changes, remoteChange(calendar.ActionSkip, r, reasonInSync))
+			continue
+		}
+		change := localChange(calendar.ActionUpdate, event, reasonChanged)
+		change.ID = r.href.String()
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	for _, event := range events {
+		uid := ics.UID(event)
+		if found[uid] {
+			continue
+		}
+		found[uid] = true
+		plan.Changes = append(plan.Changes, localChange(calendar.ActionCreate, event, reasonNew))
+	}
+
+	return plan, nil
+}
+
+// PlanDeleteAll computes what DeleteAll would remove, without modifying the CalDAV calendar
+func (c *Calendar) PlanDeleteAll(nDays int) (calendar.Plan, error) {
+	plan := calendar.Plan{Calendar: c.String()}
+
+	now := time.Now()
+	remote, err := c.getRemoteEvents(now.AddDate(0, 0, -1), now.AddDate(0, 0, nDays))
+	if err != nil {
+		return plan, fmt.Errorf("getting all events failed: %w", err)
+	}
+
+	for _, r := range remote {
+		// Only delete events that were created by calsync
+		if r.managed {
+			plan.Changes = append(plan.Changes, remoteChange(calendar.ActionDelete, r, reasonDeleteAll))
+		} else {
+			plan.Changes = append(plan.Changes, remoteChange(calendar.ActionIgnore, r, reasonNotManaged))
+		}
+	}
+
+	return plan, nil
+}
+
+// ApplyPlan makes the changes in the plan. Resources that changed on the server since they
+// were planned are left alone, like every change that failed they're returned joined.
+func (c *Calendar) ApplyPlan(plan calendar.Plan) error {
+	var errs []error
+	for _, change := range plan.Changes {
+		var err error
+		switch change.Action {
+		case calendar.ActionSkip, calendar.ActionIgnore, calendar.ActionPreserve:
+			slog.Info("Skipping", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+		case calendar.ActionCreate:
+			err = c.putEvent(change.Event, "")
+			if err == nil {
+				slog.Info("Event created", "summary", change.Title, "start", change.Start, "end", change.End)
+			}
+		case calendar.ActionUpdate:
+			slog.Info("Changed, updating", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+			err = c.putEvent(change.Event, change.ID)
+		case calendar.ActionDelete:
+			slog.Info("Deleting", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+			err = c.deleteEvent(change.ID)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", change.Action, change.Title, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// putEvent writes event, to href when it replaces an existing resource
+func (c *Calendar) putEvent(event calendar.Event, href string) error {
+	uid := ics.UID(event)
+
+	var target *url.URL
+	if href != "" {
+		parsed, err := url.Parse(href)
+		if err != nil {
+			return fmt.Errorf("parsing href: %w", err)
+		}
+		target = parsed
+	} else {
+		collection, err := c.collectionURL(c.ctx)
+		if err != nil {
+			return err
+		}
+		target = resourceURL(collection, uid)
+	}
+
+	var data bytes.Buffer
+	err := ics.Encode(&data, []ics.VEvent{{
+		UID:   uid,
+		Event: event,
+		Stamp: time.Now(),
+		Extra: ics.SourceProperties(event),
+	}})
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	// A new resource is only written when it doesn't exist, an existing one when it's unchanged
+	return c.put(c.ctx, target, c.etags[target.String()], data.Bytes())
+}
+
+func (c *Calendar) deleteEvent(href string) error {
+	target, err := url.Parse(href)
+	if err != nil {
+		return fmt.Errorf("parsing href: %w", err)
+	}
+	return c.delete(c.ctx, target, c.etags[href])
+}
+
+// remoteChange describes a change to an event that exists in the CalDAV calendar
+func remoteChange(action calendar.Action, r remoteEvent, reason string) calendar.Change {
+	return calendar.Change{
+		Action: action,
+		Title:  r.title,
+		Start:  r.start,
+		End:    r.end,
+		ID:     r.href.String(),
+		Reason: reason,
+		Source: r.source,
+	}
+}
+
+// localChange describes a change that writes a source event to the CalDAV calendar
+func localChange(action calendar.Action, event calendar.Event, reason string) calendar.Change {
+	change := calendar.Change{
+		Action: action,
+		Title:  event.Title,
+		Start:  event.Start.Format(time.RFC3339),
+		End:    event.Stop.Format(time.RFC3339),
+		Reason: reason,
+		Event:  event,
+	}
+	if event.AllDay {
+		change.Start = event.Start.Format(calendar.DateLayout)
+		change.End = event.Stop.Format(calendar.DateLayout)
+	}
+	return change
+}
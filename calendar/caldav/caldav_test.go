@@ -0,0 +1,500 @@
+package caldav
+
+import (
+	"calsync/calendar"
+	"calsync/calendar/ics"
+	"calsync/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	principalPath  = "/dav/principals/user/"
+	homePath       = "/dav/calendars/user/"
+	collectionPath = "/dav/calendars/user/work/"
+	testUsername   = "user"
+	testPassword   = "secret"
+)
+
+// davServer is an in-process CalDAV server, with a principal whose home has a single calendar
+type davServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	resources map[string]*davResource
+	nextETag  int
+	// Requests has the method, path and conditional header of each write
+	Requests []string
+	// NoExpand rejects calendar-query reports asking to expand recurring events, with
+	// ExpandStatus or 501 Not Implemented
+	NoExpand     bool
+	ExpandStatus int
+	// ReportStatus fails the other calendar-query reports when set
+	ReportStatus int
+	// ExpandReports counts calendar-query reports asking to expand recurring events
+	ExpandReports int
+}
+
+type davResource struct {
+	etag string
+	data string
+	// expanded is returned instead of data when recurring events are expanded
+	expanded string
+}
+
+func newDAVServer(t *testing.T) *davServer {
+	s := &davServer{
+		t:         t,
+		resources: make(map[string]*davResource),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// add stores a resource in the collection, as if created by another client
+func (s *davServer) add(name string, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[collectionPath+name] = &davResource{etag: s.newETag(), data: data}
+}
+
+// edit changes the ETag of the resource with a summary, as if edited by another client
+func (s *davServer) edit(summary string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.resources {
+		if strings.Contains(r.data, "SUMMARY:"+summary+"\r\n") {
+			r.etag = s.newETag()
+		}
+	}
+}
+
+// summaries returns the SUMMARY of every resource, sorted
+func (s *davServer) summaries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := make([]string, 0, len(s.resources))
+	for _, r := range s.resources {
+		for _, line := range strings.Split(r.data, "\r\n") {
+			if summary, ok := strings.CutPrefix(line, "SUMMARY:"); ok {
+				summaries = append(summaries, summary)
+			}
+		}
+	}
+	sort.Strings(summaries)
+	return summaries
+}
+
+func (s *davServer) newETag() string {
+	s.nextETag++
+	return strconv.Quote("etag-" + strconv.Itoa(s.nextETag))
+}
+
+func (s *davServer) handle(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != testUsername || password != testPassword {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case "PROPFIND":
+		s.handlePropfind(w, r)
+	case "REPORT":
+		s.handleReport(w, r)
+	case http.MethodPut:
+		s.handlePut(w, r)
+	case http.MethodDelete:
+		s.handleDelete(w, r)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *davServer) handlePropfind(w http.ResponseWriter, r *http.Request) {
+	var responses []string
+	switch r.URL.Path {
+	case principalPath:
+		responses = append(responses, davResponse(principalPath,
+			"<D:resourcetype><D:principal/></D:resourcetype>"+
+				"<D:current-user-principal><D:href>"+principalPath+"</D:href></D:current-user-principal>"+
+				"<C:calendar-home-set><D:href>"+homePath+"</D:href></C:calendar-home-set>"))
+	case homePath:
+		responses = append(responses, davResponse(homePath, "<D:resourcetype><D:collection/></D:resourcetype>"))
+		if r.Header.Get("Depth") == "1" {
+			responses = append(responses,
+				davResponse(homePath+"inbox/", "<D:resourcetype><D:collection/><C:schedule-inbox/></D:resourcetype>"),
+				davResponse(collectionPath, "<D:resourcetype><D:collection/><C:calendar/></D:resourcetype>"))
+		}
+	case collectionPath, strings.TrimSuffix(collectionPath, "/"):
+		responses = append(responses, davResponse(collectionPath, "<D:resourcetype><D:collection/><C:calendar/></D:resourcetype>"))
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	writeMultistatus(w, responses)
+}
+
+func (s *davServer) handleReport(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if r.URL.Path != collectionPath || !strings.Contains(string(body), "<C:time-range ") {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	expand := strings.Contains(string(body), "<C:expand ")
+	if expand {
+		s.ExpandReports++
+		if s.NoExpand {
+			status := http.StatusNotImplemented
+			if s.ExpandStatus != 0 {
+				status = s.ExpandStatus
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+	if s.ReportStatus != 0 {
+		http.Error(w, http.StatusText(s.ReportStatus), s.ReportStatus)
+		return
+	}
+
+	var responses []string
+	for path, res := range s.resources {
+		data := res.data
+		if expand && res.expanded != "" {
+			data = res.expanded
+		}
+		responses = append(responses, davResponse(path,
+			"<D:getetag>"+xmlEscape(res.etag)+"</D:getetag><C:calendar-data>"+xmlEscape(data)+"</C:calendar-data>"))
+	}
+	writeMultistatus(w, responses)
+}
+
+func (s *davServer) handlePut(w http.ResponseWriter, r *http.Request) {
+	existing, exists := s.resources[r.URL.Path]
+	if !s.preconditions(w, r, existing, exists) {
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	res := &davResource{etag: s.newETag(), data: string(body)}
+	s.resources[r.URL.Path] = res
+
+	w.Header().Set("ETag", res.etag)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *davServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	existing, exists := s.resources[r.URL.Path]
+	if !exists {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !s.preconditions(w, r, existing, exists) {
+		return
+	}
+
+	delete(s.resources, r.URL.Path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// preconditions checks If-Match and If-None-Match, logging the request
+func (s *davServer) preconditions(w http.ResponseWriter, r *http.Request, existing *davResource, exists bool) bool {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	s.Requests = append(s.Requests, strings.TrimSpace(fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, ifMatch+ifNoneMatch)))
+
+	if (ifNoneMatch == "*" && exists) || (ifMatch != "" && (!exists || existing.etag != ifMatch)) {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func davResponse(href string, props string) string {
+	return "<D:response><D:href>" + href + "</D:href><D:propstat><D:prop>" + props +
+		"</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>"
+}
+
+func writeMultistatus(w http.ResponseWriter, responses []string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">`+
+		strings.Join(responses, "")+`</D:multistatus>`)
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
+
+func newTestCalendar(t *testing.T, server *davServer, path string) *Calendar {
+	t.Helper()
+
+	cal, err := New(context.Background(), config.CalDAV{
+		Name:            "work",
+		URL:             server.URL + path,
+		Username:        testUsername,
+		PasswordCommand: "echo " + testPassword,
+	})
+	require.NoError(t, err)
+	return cal
+}
+
+const manualEvent = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\n" +
+	"UID:manual-1@example.com\r\nDTSTAMP:20260101T000000Z\r\nDTSTART:%s\r\nDTEND:%s\r\n" +
+	"SUMMARY:Manual\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+func TestDiscover(t *testing.T) {
+	server := newDAVServer(t)
+
+	for _, path := range []string{principalPath, homePath, collectionPath, strings.TrimSuffix(collectionPath, "/")} {
+		t.Run(path, func(t *testing.T) {
+			cal := newTestCalendar(t, server, path)
+
+			collection, err := cal.collectionURL(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, server.URL+collectionPath, collection.String())
+		})
+	}
+}
+
+func TestSyncToDest(t *testing.T) {
+	server := newDAVServer(t)
+
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	server.add("manual.ics", fmt.Sprintf(manualEvent, start.UTC().Format(utcLayout), start.Add(time.Hour).UTC().Format(utcLayout)))
+
+	standup := calendar.Event{Title: "Standup", Start: start, Stop: start.Add(15 * time.Minute), UID: "standup@example.com", Calendar: "ICS Calendar: team"}
+	review := calendar.Event{Title: "Review, Q3; budget", Start: start.Add(time.Hour), Stop: start.Add(2 * time.Hour), UID: "review@example.com"}
+	offsite := calendar.Event{Title: "Offsite", Start: start.Truncate(24 * time.Hour), Stop: start.Truncate(24*time.Hour).AddDate(0, 0, 2), AllDay: true}
+
+	cal := newTestCalendar(t, server, principalPath)
+	require.NoError(t, cal.SyncToDest([]calendar.Event{standup, review}))
+	assert.Equal(t, []string{"Manual", "Review\\, Q3\\; budget", "Standup"}, server.summaries())
+	for _, req := range server.Requests {
+		assert.True(t, strings.HasPrefix(req, "PUT "+collectionPath) && strings.HasSuffix(req, " *"), "create must not overwrite: %s", req)
+	}
+
+	// A new client sees the events it wrote before as its own
+	standup.Title = "Daily"
+	cal = newTestCalendar(t, server, collectionPath)
+	plan, err := cal.PlanSync([]calendar.Event{standup, offsite})
+	require.NoError(t, err)
+
+	want := map[calendar.Action]int{
+		calendar.ActionCreate: 1,
+		calendar.ActionUpdate: 1,
+		calendar.ActionDelete: 1,
+		calendar.ActionSkip:   0,
+		calendar.ActionIgnore: 1,
+	}
+	for action, n := range want {
+		assert.Equal(t, n, plan.Count(action), "plan %s count", action)
+	}
+	for _, change := range plan.Changes {
+		if change.Action == calendar.ActionDelete {
+			assert.Empty(t, change.Source, "source of events synced without one")
+		}
+	}
+
+	server.Requests = nil
+	require.NoError(t, cal.ApplyPlan(plan))
+	assert.Equal(t, []string{"Daily", "Manual", "Offsite"}, server.summaries())
+	for _, req := range server.Requests {
+		if strings.HasPrefix(req, "DELETE") || strings.Contains(req, "etag") {
+			assert.Contains(t, req, `"etag-`, "updates and deletes must be conditional: %s", req)
+		}
+	}
+
+	// Nothing changed, nothing written
+	server.Requests = nil
+	plan, err = cal.PlanSync([]calendar.Event{standup, offsite})
+	require.NoError(t, err)
+	assert.Equal(t, 2, plan.Count(calendar.ActionSkip))
+	require.NoError(t, cal.ApplyPlan(plan))
+	assert.Empty(t, server.Requests)
+}
+
+func TestApplyPlanConflict(t *testing.T) {
+	server := newDAVServer(t)
+	cal := newTestCalendar(t, server, collectionPath)
+
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	event := calendar.Event{Title: "Standup", Start: start, Stop: start.Add(15 * time.Minute), UID: "standup@example.com"}
+	require.NoError(t, cal.SyncToDest([]calendar.Event{event}))
+
+	event.Title = "Daily"
+	plan, err := cal.PlanSync([]calendar.Event{event})
+	require.NoError(t, err)
+	require.Equal(t, 1, plan.Count(calendar.ActionUpdate))
+
+	// Edited on the server after planning
+	server.edit("Standup")
+
+	err = cal.ApplyPlan(plan)
+	assert.True(t, errors.Is(err, errPreconditionFailed), "got %v", err)
+	assert.Equal(t, []string{"Standup"}, server.summaries())
+}
+
+func TestPlanDeleteAll(t *testing.T) {
+	server := newDAVServer(t)
+	cal := newTestCalendar(t, server, collectionPath)
+
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	server.add("manual.ics", fmt.Sprintf(manualEvent, start.UTC().Format(utcLayout), start.Add(time.Hour).UTC().Format(utcLayout)))
+	require.NoError(t, cal.SyncToDest([]calendar.Event{{Title: "Standup", Start: start, Stop: start.Add(time.Hour), UID: "standup@example.com"}}))
+
+	require.NoError(t, cal.DeleteAll(7))
+	assert.Equal(t, []string{"Manual"}, server.summaries())
+}
+
+func TestNewPassword(t *testing.T) {
+	server := newDAVServer(t)
+
+	_, err := New(context.Background(), config.CalDAV{URL: server.URL, Username: testUsername, PasswordCommand: "echo oops >&2; exit 3"})
+	assert.ErrorContains(t, err, "oops")
+
+	cal, err := New(context.Background(), config.CalDAV{URL: server.URL + collectionPath, Username: testUsername, PasswordCommand: "echo wrong"})
+	require.NoError(t, err)
+	_, err = cal.PlanSync([]calendar.Event{{Title: "Standup", Start: time.Now(), Stop: time.Now().Add(time.Hour)}})
+	assert.ErrorContains(t, err, "401")
+}
+
+// vcalendar wraps VEVENTs, given as lines
+func vcalendar(vevents ...[]string) string {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//test//EN"}
+	for _, vevent := range vevents {
+		lines = append(lines, "BEGIN:VEVENT")
+		lines = append(lines, vevent...)
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func TestGetEvents(t *testing.T) {
+	weekly := vcalendar(
+		[]string{"UID:weekly@example.com", "DTSTAMP:20260101T000000Z", "DTSTART:20260223T090000Z", "DTEND:20260223T093000Z",
+			"RRULE:FREQ=WEEKLY;COUNT=6", "SUMMARY:Weekly"},
+		[]string{"UID:weekly@example.com", "DTSTAMP:20260101T000000Z", "RECURRENCE-ID:20260309T090000Z",
+			"DTSTART:20260309T100000Z", "DTEND:20260309T103000Z", "SUMMARY:Weekly (moved)"},
+	)
+	weeklyExpanded := vcalendar(
+		[]string{"UID:weekly@example.com", "DTSTAMP:20260101T000000Z", "RECURRENCE-ID:20260302T090000Z", "DTSTART:20260302T090000Z", "DTEND:20260302T093000Z", "SUMMARY:Weekly"},
+		[]string{"UID:weekly@example.com", "DTSTAMP:20260101T000000Z", "RECURRENCE-ID:20260309T090000Z", "DTSTART:20260309T100000Z", "DTEND:20260309T103000Z", "SUMMARY:Weekly (moved)"},
+		[]string{"UID:weekly@example.com", "DTSTAMP:20260101T000000Z", "RECURRENCE-ID:20260316T090000Z", "DTSTART:20260316T090000Z", "DTEND:20260316T093000Z", "SUMMARY:Weekly"},
+	)
+
+	start := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 3, 23, 0, 0, 0, 0, time.UTC)
+	want := []string{
+		"Midnight 2026-03-02T00:00:00Z",
+		"Weekly 2026-03-02T09:00:00Z",
+		"Planning 2026-03-05T09:00:00Z",
+		"Offsite all-day",
+		"Weekly (moved) 2026-03-09T10:00:00Z",
+		"Weekly 2026-03-16T09:00:00Z",
+	}
+
+	for _, noExpand := range []bool{false, true} {
+		t.Run(fmt.Sprintf("noExpand=%t", noExpand), func(t *testing.T) {
+			server := newDAVServer(t)
+			server.NoExpand = noExpand
+			server.add("midnight.ics", vcalendar([]string{"UID:midnight@example.com", "DTSTAMP:20260101T000000Z",
+				"DTSTART:20260302T000000Z", "DTEND:20260302T003000Z", "SUMMARY:Midnight"}))
+			server.add("planning.ics", vcalendar([]string{"UID:planning@example.com", "DTSTAMP:20260101T000000Z",
+				"DTSTART;TZID=Europe/Berlin:20260305T100000", "DTEND;TZID=Europe/Berlin:20260305T110000", "SUMMARY:Planning",
+				"ATTENDEE;CN=Jane;PARTSTAT=ACCEPTED:mailto:jane@example.com"}))
+			server.add("offsite.ics", vcalendar([]string{"UID:offsite@example.com", "DTSTAMP:20260101T000000Z",
+				"DTSTART;VALUE=DATE:20260306", "DTEND;VALUE=DATE:20260307", "SUMMARY:Offsite"}))
+			server.add("synced.ics", vcalendar([]string{"UID:0123456789abcdef" + ics.UIDSuffix, "DTSTAMP:20260101T000000Z",
+				"DTSTART:20260304T090000Z", "DTEND:20260304T100000Z", "SUMMARY:Written by calsync"}))
+			server.add("weekly.ics", weekly)
+			server.resources[collectionPath+"weekly.ics"].expanded = weeklyExpanded
+
+			cal := newTestCalendar(t, server, collectionPath)
+			for range 2 {
+				events, err := cal.GetEvents(context.Background(), start, end)
+				require.NoError(t, err)
+
+				calendar.Events(events).SortStartTime()
+				got := make([]string, 0, len(events))
+				for _, e := range events {
+					if e.AllDay {
+						got = append(got, e.Title+" all-day")
+						continue
+					}
+					got = append(got, e.Title+" "+e.Start.UTC().Format(time.RFC3339))
+				}
+				assert.Equal(t, want, got)
+			}
+
+			if noExpand {
+				assert.Equal(t, 1, server.ExpandReports, "expanding must not be retried once refused")
+			} else {
+				assert.Equal(t, 2, server.ExpandReports)
+			}
+		})
+	}
+}
+
+func TestGetEventsExpandFallback(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 3, 23, 0, 0, 0, 0, time.UTC)
+
+	t.Run("failed fallback", func(t *testing.T) {
+		server := newDAVServer(t)
+		server.NoExpand = true
+		server.ReportStatus = http.StatusServiceUnavailable
+		cal := newTestCalendar(t, server, collectionPath)
+
+		_, err := cal.GetEvents(context.Background(), start, end)
+		require.Error(t, err)
+
+		// The server may have failed for another reason, expanding is tried again
+		server.ReportStatus = 0
+		_, err = cal.GetEvents(context.Background(), start, end)
+		require.NoError(t, err)
+		assert.Equal(t, 2, server.ExpandReports)
+
+		_, err = cal.GetEvents(context.Background(), start, end)
+		require.NoError(t, err)
+		assert.Equal(t, 2, server.ExpandReports, "expanding must not be retried once refused")
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		server := newDAVServer(t)
+		server.NoExpand = true
+		server.ExpandStatus = http.StatusForbidden
+		cal := newTestCalendar(t, server, collectionPath)
+
+		for range 2 {
+			_, err := cal.GetEvents(context.Background(), start, end)
+			assert.ErrorContains(t, err, "403")
+		}
+		assert.Equal(t, 2, server.ExpandReports, "403 Forbidden must not turn off expanding")
+	})
+}
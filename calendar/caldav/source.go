@@ -0,0 +1,91 @@
+package caldav
+
+import (
+	"calsync/calendar"
+	"calsync/calendar/ics"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	gocal "github.com/apognu/gocal"
+)
+
+// GetEvents lists the events between start and end. Recurring events are expanded by the
+// server when it supports it, and by calsync otherwise. Events written by calsync are left
+// out, so that CalDAV calendars can be synced into each other.
+func (c *Calendar) GetEvents(ctx context.Context, start time.Time, end time.Time) ([]calendar.Event, error) {
+	resources, err := c.listResources(ctx, start, end, !c.noExpand)
+	if err != nil && !c.noExpand && expandUnsupported(err) {
+		slog.Info("CalDAV server doesn't expand recurring events, expanding them locally", "calendar", c.String(), "error", err)
+		resources, err = c.listResources(ctx, start, end, false)
+		// Unless the server answers without expand, it may have failed for another reason
+		if err == nil {
+			c.noExpand = true
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]calendar.Event, 0, len(resources))
+	for _, r := range resources {
+		parsed, err := parseEvents(r.data, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", r.href, err)
+		}
+		for _, e := range parsed {
+			if strings.HasSuffix(e.Uid, ics.UIDSuffix) {
+				continue
+			}
+			events = append(events, ics.NewEvent(e))
+		}
+	}
+
+	return events, nil
+}
+
+// expandUnsupported tells if the REPORT may have failed because of the expand element, servers
+// without support reject it instead of ignoring it. 403 Forbidden is about access, not expand.
+func expandUnsupported(err error) bool {
+	var httpErr *httpError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.code {
+	case http.StatusBadRequest, http.StatusNotImplemented, http.StatusUnsupportedMediaType:
+		return true
+	}
+	return false
+}
+
+// parseEvents returns the VEVENTs of an iCalendar object overlapping start and end. Recurring
+// events the server didn't expand are expanded, overridden instances replace theirs.
+func parseEvents(data string, start time.Time, end time.Time) ([]gocal.Event, error) {
+	// gocal's bounds are exclusive, an instance starting right at start would be dropped.
+	// They're widened and the range is checked below instead.
+	boundStart, boundEnd := start.Add(-time.Second), end.Add(time.Second)
+	parser := gocal.NewParser(strings.NewReader(data))
+	parser.Start, parser.End = &boundStart, &boundEnd
+	// Events the server returned are in range, but overridden instances of recurring events
+	// it didn't expand may not be
+	parser.SkipBounds = true
+	if err := parser.Parse(); err != nil {
+		return nil, err
+	}
+
+	events := make([]gocal.Event, 0, len(parser.Events))
+	for _, e := range parser.Events {
+		if e.Start == nil || e.End == nil {
+			continue
+		}
+		if e.Start.Before(end) && (e.End.After(start) || (e.End.Equal(*e.Start) && !e.Start.Before(start))) {
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
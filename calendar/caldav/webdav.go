@@ -0,0 +1,324 @@
+package caldav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// errPreconditionFailed is returned when the resource changed on the server since it was listed
+var errPreconditionFailed = errors.New("changed on the server since it was planned, rerun to sync it")
+
+// multistatus is a WebDAV 207 Multi-Status response (RFC 4918 14.16)
+type multistatus struct {
+	XMLName   xml.Name   `xml:"DAV: multistatus"`
+	Responses []response `xml:"DAV: response"`
+}
+
+type response struct {
+	Href     string     `xml:"DAV: href"`
+	Propstat []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+// prop has every property calsync asks for, only the requested ones are set
+type prop struct {
+	ResourceType         resourceType `xml:"DAV: resourcetype"`
+	CurrentUserPrincipal hrefProp     `xml:"DAV: current-user-principal"`
+	CalendarHomeSet      hrefProp     `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+	ETag                 string       `xml:"DAV: getetag"`
+	CalendarData         string       `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+}
+
+type resourceType struct {
+	Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+}
+
+type hrefProp struct {
+	Href string `xml:"DAV: href"`
+}
+
+// found merges the properties of the propstats with a 200 status, the others weren't found
+func (r response) found() prop {
+	var merged prop
+	for _, ps := range r.Propstat {
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+		if ps.Prop.ResourceType.Calendar != nil {
+			merged.ResourceType = ps.Prop.ResourceType
+		}
+		if ps.Prop.CurrentUserPrincipal.Href != "" {
+			merged.CurrentUserPrincipal = ps.Prop.CurrentUserPrincipal
+		}
+		if ps.Prop.CalendarHomeSet.Href != "" {
+			merged.CalendarHomeSet = ps.Prop.CalendarHomeSet
+		}
+		if ps.Prop.ETag != "" {
+			merged.ETag = ps.Prop.ETag
+		}
+		if ps.Prop.CalendarData != "" {
+			merged.CalendarData = ps.Prop.CalendarData
+		}
+	}
+	return merged
+}
+
+const propfindDiscovery = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:resourcetype/>
+    <D:current-user-principal/>
+    <C:calendar-home-set/>
+  </D:prop>
+</D:propfind>`
+
+const reportCalendarQuery = `<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+    %s
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="%s" end="%s"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`
+
+// discover finds the calendar collection of the configured URL. It's either the collection
+// itself, or the first calendar of the calendar home of the URL or of its principal.
+func (c *Calendar) discover(ctx context.Context) (*url.URL, error) {
+	base, err := url.Parse(c.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing URL: %w", err)
+	}
+
+	responses, err := c.multistatus(ctx, "PROPFIND", base, "0", propfindDiscovery)
+	if err != nil {
+		return nil, fmt.Errorf("discovering calendar at %s: %w", base, err)
+	}
+	props := firstFound(responses)
+	if props.ResourceType.Calendar != nil {
+		return withTrailingSlash(base), nil
+	}
+
+	home := base
+	switch {
+	case props.CalendarHomeSet.Href != "":
+		home = resolve(base, props.CalendarHomeSet.Href)
+	case props.CurrentUserPrincipal.Href != "":
+		principal := resolve(base, props.CurrentUserPrincipal.Href)
+		responses, err := c.multistatus(ctx, "PROPFIND", principal, "0", propfindDiscovery)
+		if err != nil {
+			return nil, fmt.Errorf("discovering calendar home of %s: %w", principal, err)
+		}
+		if href := firstFound(responses).CalendarHomeSet.Href; href != "" {
+			home = resolve(principal, href)
+		}
+	}
+
+	responses, err = c.multistatus(ctx, "PROPFIND", home, "1", propfindDiscovery)
+	if err != nil {
+		return nil, fmt.Errorf("listing calendars at %s: %w", home, err)
+	}
+	for _, r := range responses {
+		if r.found().ResourceType.Calendar != nil {
+			return withTrailingSlash(resolve(home, r.Href)), nil
+		}
+	}
+
+	return nil, fmt.Errorf("no calendar collection found at %s", base)
+}
+
+// resource is a calendar object resource of the collection
+type resource struct {
+	href *url.URL
+	etag string
+	data string
+}
+
+// listResources returns the resources of the collection with events between start and end.
+// With expand, the server returns each instance of recurring events in the range instead.
+func (c *Calendar) listResources(ctx context.Context, start time.Time, end time.Time, expand bool) ([]resource, error) {
+	collection, err := c.collectionURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rangeStart, rangeEnd := start.UTC().Format(utcLayout), end.UTC().Format(utcLayout)
+	calendarData := "<C:calendar-data/>"
+	if expand {
+		calendarData = fmt.Sprintf(`<C:calendar-data><C:expand start="%s" end="%s"/></C:calendar-data>`, rangeStart, rangeEnd)
+	}
+	body := fmt.Sprintf(reportCalendarQuery, calendarData, rangeStart, rangeEnd)
+	responses, err := c.multistatus(ctx, "REPORT", collection, "1", body)
+	if err != nil {
+		return nil, fmt.Errorf("listing events of %s: %w", collection, err)
+	}
+
+	resources := make([]resource, 0, len(responses))
+	for _, r := range responses {
+		props := r.found()
+		if props.CalendarData == "" {
+			continue
+		}
+		resources = append(resources, resource{
+			href: resolve(collection, r.Href),
+			etag: props.ETag,
+			data: props.CalendarData,
+		})
+	}
+
+	return resources, nil
+}
+
+// put writes the iCalendar data to href. Without etag, the resource must not exist yet.
+func (c *Calendar) put(ctx context.Context, href *url.URL, etag string, data []byte) error {
+	req, err := c.newRequest(ctx, http.MethodPut, href, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	} else {
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	return c.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+// delete removes the resource at href, unless it changed since etag was listed
+func (c *Calendar) delete(ctx context.Context, href *url.URL, etag string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, href, nil)
+	if err != nil {
+		return err
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	// Already gone is as good as deleted
+	return c.do(req, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+// multistatus sends a PROPFIND or REPORT request, returning the responses of the 207 Multi-Status
+func (c *Calendar) multistatus(ctx context.Context, method string, target *url.URL, depth string, body string) ([]response, error) {
+	req, err := c.newRequest(ctx, method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", depth)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusError(resp)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("decoding %s response: %w", method, err)
+	}
+
+	return ms.Responses, nil
+}
+
+func (c *Calendar) newRequest(ctx context.Context, method string, target *url.URL, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.Username != "" {
+		req.SetBasicAuth(c.cfg.Username, c.password)
+	}
+	return req, nil
+}
+
+// do sends req, any status but the expected ones is an error
+func (c *Calendar) do(req *http.Request, expected ...int) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errPreconditionFailed
+	}
+	return statusError(resp)
+}
+
+// httpError is a response with an unexpected status
+type httpError struct {
+	method string
+	path   string
+	status string
+	code   int
+	body   string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.method, e.path, e.status, e.body)
+}
+
+func statusError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &httpError{
+		method: resp.Request.Method,
+		path:   resp.Request.URL.Path,
+		status: resp.Status,
+		code:   resp.StatusCode,
+		body:   strings.TrimSpace(string(b)),
+	}
+}
+
+func firstFound(responses []response) prop {
+	if len(responses) == 0 {
+		return prop{}
+	}
+	return responses[0].found()
+}
+
+// resolve returns href, usually an absolute path, relative to base
+func resolve(base *url.URL, href string) *url.URL {
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return base
+	}
+	return base.ResolveReference(ref)
+}
+
+// withTrailingSlash makes resources resolve inside the collection
+func withTrailingSlash(u *url.URL) *url.URL {
+	if strings.HasSuffix(u.Path, "/") {
+		return u
+	}
+	c := *u
+	c.Path += "/"
+	return &c
+}
@@ -2,6 +2,7 @@ package calendar
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"sort"
@@ -14,7 +15,8 @@ type Calendar interface {
 	String() string
 
 	// GetEvents retrieves events from the calendar within the specified time range.
-	GetEvents(start time.Time, end time.Time) ([]Event, error)
+	// Fetching stops when ctx is done.
+	GetEvents(ctx context.Context, start time.Time, end time.Time) ([]Event, error)
 
 	// PutEvents adds or updates events in the calendar.
 	PutEvents() error
@@ -25,17 +27,55 @@ type Calendar interface {
 
 	// SyncToDest synchronizes events to the destination calendar.
 	SyncToDest([]Event) error
+
+	// PlanSync computes the changes SyncToDest would make, without making them.
+	PlanSync([]Event) (Plan, error)
+
+	// ApplyPlan makes the changes computed by PlanSync or PlanDeleteAll.
+	ApplyPlan(Plan) error
+
+	// PlanDeleteAll computes the events DeleteAll would remove, without removing them.
+	PlanDeleteAll(nDays int) (Plan, error)
 }
 
+// DateLayout is the layout used for the date-only part of all-day events
+const DateLayout = "2006-01-02"
+
 type Event struct {
 	Title       string
 	Notes       string
+	Location    string
 	Start, Stop time.Time
 	UID         string
+
+	// RecurrenceID tells instances of a recurring event apart, they share the UID. It's the
+	// start the recurrence rule scheduled the instance at, which stays the same when only
+	// that instance is moved. Zero for events that aren't recurring.
+	RecurrenceID time.Time
+
+	// Calendar is the source calendar the event came from, as in Calendar.String()
+	Calendar string
+
+	// Organizer and Attendees are informational only, they are never
+	// turned into invitations on the target.
+	Organizer *Attendee
+	Attendees []Attendee
+
+	// AllDay events only carry a date, Start is the first day at midnight
+	// and Stop is the (exclusive) day after the last day at midnight.
+	AllDay bool
 }
 
 type Events []Event
 
+// Attendee is a participant of an event, Status is the RFC 5545 PARTSTAT
+// (e.g. ACCEPTED, DECLINED, NEEDS-ACTION), empty when the source doesn't know.
+type Attendee struct {
+	Name   string
+	Email  string
+	Status string
+}
+
 // SortStartTime events by start time
 func (e Events) SortStartTime() {
 	sort.Slice(e, func(i, j int) bool {
@@ -50,9 +90,18 @@ func (e Event) String() string {
 func (e Event) Hash() string {
 	var buffer bytes.Buffer
 	buffer.WriteString(e.Title)
-	buffer.WriteString(e.Start.UTC().Format(time.RFC3339))
-	buffer.WriteString(e.Stop.UTC().Format(time.RFC3339))
+	if e.AllDay {
+		buffer.WriteString(e.Start.Format(DateLayout))
+		buffer.WriteString(e.Stop.Format(DateLayout))
+	} else {
+		buffer.WriteString(e.Start.UTC().Format(time.RFC3339))
+		buffer.WriteString(e.Stop.UTC().Format(time.RFC3339))
+	}
 	buffer.WriteString(e.Notes)
+	// Only added when set, so hashes of events without location stay unchanged
+	if e.Location != "" {
+		buffer.WriteString(e.Location)
+	}
 
 	md5sum := md5.Sum(buffer.Bytes())
 
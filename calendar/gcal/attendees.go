@@ -0,0 +1,67 @@
+package gcal
+
+import (
+	"calsync/calendar"
+	"strings"
+)
+
+// withAttendees returns a copy of events with organizer and attendees appended to notes.
+// As notes are part of the event hash, attendee changes are synced too.
+func withAttendees(events []calendar.Event) []calendar.Event {
+	rendered := make([]calendar.Event, len(events))
+	for i, event := range events {
+		rendered[i] = event
+
+		block := describeAttendees(event)
+		if block == "" {
+			continue
+		}
+		if event.Notes != "" {
+			rendered[i].Notes = event.Notes + "\n\n" + block
+		} else {
+			rendered[i].Notes = block
+		}
+	}
+
+	return rendered
+}
+
+// describeAttendees renders organizer and attendees as plain text, e.g.
+//
+//	Organizer: Jane Doe <jane@example.com>
+//	Attendees:
+//	- John Doe <john@example.com> (ACCEPTED)
+func describeAttendees(event calendar.Event) string {
+	var b strings.Builder
+
+	if event.Organizer != nil {
+		b.WriteString("Organizer: ")
+		b.WriteString(describeAttendee(*event.Organizer))
+		b.WriteString("\n")
+	}
+
+	if len(event.Attendees) > 0 {
+		b.WriteString("Attendees:\n")
+		for _, attendee := range event.Attendees {
+			b.WriteString("- ")
+			b.WriteString(describeAttendee(attendee))
+			if attendee.Status != "" {
+				b.WriteString(" (" + attendee.Status + ")")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func describeAttendee(attendee calendar.Attendee) string {
+	switch {
+	case attendee.Name != "" && attendee.Email != "":
+		return attendee.Name + " <" + attendee.Email + ">"
+	case attendee.Name != "":
+		return attendee.Name
+	default:
+		return attendee.Email
+	}
+}
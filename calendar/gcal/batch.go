@@ -0,0 +1,334 @@
+package gcal
+
+import (
+	"bufio"
+	"bytes"
+	"calsync/calendar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	googlecalendar "google.golang.org/api/calendar/v3"
+)
+
+// maxBatchSize is the most requests Google accepts in a single batch
+const maxBatchSize = 50
+
+// BatchFailure is a change of a batch that Google rejected
+type BatchFailure struct {
+	Change calendar.Change
+	// Status is the HTTP status of the item, 0 when it's missing from the response
+	Status int
+	Err    error
+}
+
+// BatchError is returned when some changes of a batch failed, the others were applied
+type BatchError struct {
+	Failures []BatchFailure
+	Total    int
+}
+
+func (e *BatchError) Error() string {
+	first := e.Failures[0]
+	return fmt.Sprintf("%d of %d changes failed, first: %s %q: %s", len(e.Failures), e.Total, first.Change.Action, first.Change.Title, first.Err)
+}
+
+// Events returns the source events of failed creates and updates
+func (e *BatchError) Events() []calendar.Event {
+	events := make([]calendar.Event, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		if failure.Change.Action == calendar.ActionCreate || failure.Change.Action == calendar.ActionUpdate {
+			events = append(events, failure.Change.Event)
+		}
+	}
+	return events
+}
+
+// batchItem is a single request of a batch
+type batchItem struct {
+	change calendar.Change
+	method string
+	// path is relative to the API base path
+	path string
+	body *googlecalendar.Event
+}
+
+// batchResult is the response to a batchItem
+type batchResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// applyBatched sends the creates, updates and deletes of changes to the batch endpoint,
+// maxBatchSize at a time. Items that were rate limited are sent again, other failures
+// are collected in a BatchError once every batch was sent.
+func (c *Client) applyBatched(changes []calendar.Change) error {
+	items := make([]batchItem, 0, len(changes))
+	for _, change := range changes {
+		if item, ok := c.newBatchItem(change); ok {
+			items = append(items, item)
+		}
+	}
+
+	batchErr := &BatchError{Total: len(items)}
+	for start := 0; start < len(items); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(items))
+		batchErr.Failures = append(batchErr.Failures, c.sendBatchWithRetries(items[start:end])...)
+	}
+
+	if len(batchErr.Failures) > 0 {
+		return batchErr
+	}
+	return nil
+}
+
+func (c *Client) newBatchItem(change calendar.Change) (batchItem, bool) {
+	eventsPath := "calendars/" + url.PathEscape(c.workCalID) + "/events"
+
+	switch change.Action {
+	case calendar.ActionCreate:
+		// Attendees are only rendered in the description, never notify anyone
+		return batchItem{change: change, method: http.MethodPost, path: eventsPath + "?sendUpdates=none", body: newGCalEvent(change.Event, c.cfg.Namespace)}, true
+	case calendar.ActionUpdate:
+		return batchItem{change: change, method: http.MethodPatch, path: eventsPath + "/" + url.PathEscape(change.ID) + "?sendUpdates=none", body: newGCalPatch(change.Event, c.cfg.Namespace)}, true
+	case calendar.ActionDelete:
+		return batchItem{change: change, method: http.MethodDelete, path: eventsPath + "/" + url.PathEscape(change.ID)}, true
+	}
+
+	return batchItem{}, false
+}
+
+// sendBatchWithRetries sends items, then sends rate limited and failed items again with
+// the same backoff as single requests, honoring Retry-After
+func (c *Client) sendBatchWithRetries(items []batchItem) []BatchFailure {
+	failures := make([]BatchFailure, 0)
+	for attempt := 0; ; attempt++ {
+		failed, retryable := c.sendBatch(items)
+		failures = append(failures, failed...)
+		if len(retryable) == 0 || c.retry == nil || attempt >= c.retry.maxRetries {
+			return append(failures, failuresOf(retryable)...)
+		}
+
+		delay := c.retry.backoff(attempt)
+		if retryAfter, ok := maxRetryAfter(retryable); ok {
+			delay = retryAfter
+		}
+		slog.Warn("Retrying failed batch items", "count", len(retryable), "attempt", attempt+1, "delay", delay)
+		c.retry.retries.Add(int64(len(retryable)))
+		if err := c.retry.sleep(context.Background(), delay); err != nil {
+			return append(failures, failuresOf(retryable)...)
+		}
+
+		items = make([]batchItem, 0, len(retryable))
+		for _, failure := range retryable {
+			items = append(items, failure.item)
+		}
+	}
+}
+
+// retryableItem is an item that failed with a status worth retrying
+type retryableItem struct {
+	item batchItem
+	// retryAfter is the item response's Retry-After header
+	retryAfter string
+	BatchFailure
+}
+
+// maxRetryAfter returns the longest Retry-After of the items, false when none has one
+func maxRetryAfter(retryable []retryableItem) (time.Duration, bool) {
+	var longest time.Duration
+	found := false
+	for _, r := range retryable {
+		if retryAfter, ok := parseRetryAfter(r.retryAfter); ok {
+			longest = max(longest, retryAfter)
+			found = true
+		}
+	}
+	return longest, found
+}
+
+func failuresOf(retryable []retryableItem) []BatchFailure {
+	failures := make([]BatchFailure, 0, len(retryable))
+	for _, r := range retryable {
+		failures = append(failures, r.BatchFailure)
+	}
+	return failures
+}
+
+// sendBatch sends a single batch, returning failed items and the ones to send again
+func (c *Client) sendBatch(items []batchItem) ([]BatchFailure, []retryableItem) {
+	failAll := func(err error) ([]BatchFailure, []retryableItem) {
+		failures := make([]BatchFailure, 0, len(items))
+		for _, item := range items {
+			failures = append(failures, BatchFailure{Change: item.change, Err: err})
+		}
+		return failures, nil
+	}
+
+	results, err := c.doBatch(items)
+	if err != nil {
+		return failAll(fmt.Errorf("sending batch: %w", err))
+	}
+
+	failures := make([]BatchFailure, 0)
+	retryable := make([]retryableItem, 0)
+	for i, item := range items {
+		result, ok := results[i]
+		if !ok {
+			failures = append(failures, BatchFailure{Change: item.change, Err: errors.New("missing from batch response")})
+			continue
+		}
+
+		if result.status >= 200 && result.status < 300 {
+			c.applied(item, result)
+			continue
+		}
+
+		failure := BatchFailure{
+			Change: item.change,
+			Status: result.status,
+			Err:    fmt.Errorf("%s %s: %d %s", item.method, item.path, result.status, strings.TrimSpace(string(result.body))),
+		}
+		if isRetryable(&http.Response{StatusCode: result.status, Body: io.NopCloser(bytes.NewReader(result.body))}) {
+			retryable = append(retryable, retryableItem{item: item, retryAfter: result.header.Get("Retry-After"), BatchFailure: failure})
+			continue
+		}
+		failures = append(failures, failure)
+	}
+
+	return failures, retryable
+}
+
+// applied logs and records an item that succeeded
+func (c *Client) applied(item batchItem, result batchResult) {
+	if item.method == http.MethodDelete {
+		c.recordDeleted(item.change.ID)
+		return
+	}
+
+	var event googlecalendar.Event
+	if err := json.Unmarshal(result.body, &event); err != nil {
+		slog.Warn("Couldn't decode batch response", "error", err, "summary", item.change.Title)
+		return
+	}
+	c.recordSynced(item.change, event.Id)
+
+	msg := "Event created"
+	if item.method == http.MethodPatch {
+		msg = "Event updated"
+	}
+	slog.Info(msg, "summary", event.Summary, "start", eventTime(event.Start), "end", eventTime(event.End))
+}
+
+// doBatch sends items as a multipart/mixed request, results are keyed by item index
+func (c *Client) doBatch(items []batchItem) (map[int]batchResult, error) {
+	base, err := url.Parse(c.Svc.BasePath)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base path: %w", err)
+	}
+	// https://www.googleapis.com/calendar/v3/ batches to https://www.googleapis.com/batch/calendar/v3
+	apiPath := base.Path
+	if !strings.HasSuffix(apiPath, "/") {
+		apiPath += "/"
+	}
+	batchURL := *base
+	batchURL.Path = "/batch" + strings.TrimSuffix(apiPath, "/")
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for i, item := range items {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-Id":   {"<item-" + strconv.Itoa(i) + ">"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(part, "%s %s HTTP/1.1\r\n", item.method, apiPath+item.path)
+		if item.body != nil {
+			b, err := json.Marshal(item.body)
+			if err != nil {
+				return nil, fmt.Errorf("encoding event %q: %w", item.change.Title, err)
+			}
+			fmt.Fprintf(part, "Content-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(b), b)
+		} else {
+			fmt.Fprint(part, "\r\n")
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	// The transport charges the limiter once for the whole batch. Google counts each item
+	// against the quota, items that exceed it are rate limited and sent again.
+	req, err := http.NewRequest(http.MethodPost, batchURL.String(), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("batch request failed: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	return parseBatchResponse(resp)
+}
+
+// parseBatchResponse reads the multipart/mixed response, each part is an HTTP response
+// whose Content-ID refers to the item it answers
+func parseBatchResponse(resp *http.Response) (map[int]batchResult, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("unexpected batch response content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	results := make(map[int]batchResult)
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return results, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading batch response: %w", err)
+		}
+
+		contentID := strings.Trim(part.Header.Get("Content-Id"), "<>")
+		index, err := strconv.Atoi(strings.TrimPrefix(contentID, "response-item-"))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected Content-ID %q in batch response", contentID)
+		}
+
+		itemResp, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, fmt.Errorf("reading batch response of item %d: %w", index, err)
+		}
+		b, err := io.ReadAll(itemResp.Body)
+		itemResp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading batch response of item %d: %w", index, err)
+		}
+
+		results[index] = batchResult{status: itemResp.StatusCode, header: itemResp.Header, body: b}
+	}
+}
@@ -3,6 +3,7 @@ package gcal
 import (
 	"calsync/calendar"
 	"calsync/config"
+	"calsync/state"
 	"context"
 	"encoding/json"
 	"errors"
@@ -26,24 +27,59 @@ type Client struct {
 	http      *http.Client
 	cfg       config.Google
 	workCalID string
+
+	// syncStateFile keeps the sync token between runs, events are always fully listed when empty
+	syncStateFile string
+	// dryRun keeps the sync state on disk unchanged
+	dryRun bool
+	// eventStateFile keeps the Google event each source event was synced to, not kept when empty
+	eventStateFile string
+	// events is the state loaded from eventStateFile, by the first plan
+	events *state.Store
+	// retry rate limits and retries API calls, nil in tests
+	retry *retryTransport
 }
 
-func New(ctx context.Context, cfg config.Google, oauthCfg *oauth2.Config) (*Client, error) {
+func New(ctx context.Context, cfg config.Google, syncCfg config.Sync, oauthCfg *oauth2.Config) (*Client, error) {
 	httpClient := newClient(cfg, oauthCfg)
+	requestsPerSecond, maxRetries := syncCfg.RateLimits()
+	retry := newRetryTransport(httpClient.Transport, requestsPerSecond, maxRetries)
+	httpClient.Transport = retry
+
 	svc, err := gcalendar.NewService(ctx, option.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, fmt.Errorf("getting calendar service: %s", err)
 	}
 
 	return &Client{
-		Svc:       svc,
-		http:      httpClient,
-		cfg:       cfg,
-		workCalID: cfg.Id,
+		Svc:            svc,
+		http:           httpClient,
+		cfg:            cfg,
+		workCalID:      cfg.Id,
+		syncStateFile:  cfg.SyncStateFile(),
+		eventStateFile: cfg.EventStateFile(),
+		retry:          retry,
 	}, nil
 }
 
+// DryRun leaves the sync state on disk untouched, so that planning without applying
+// doesn't change what the next sync sees
+func (c *Client) DryRun() {
+	c.dryRun = true
+}
+
+// Retries returns how many API calls were retried so far
+func (c *Client) Retries() int {
+	if c.retry == nil {
+		return 0
+	}
+	return int(c.retry.retries.Load())
+}
+
 func (c *Client) String() string {
+	if c.cfg.Name != "" {
+		return fmt.Sprintf("Google Calendar: %s", c.cfg.Name)
+	}
 	return fmt.Sprintf("Google Calendar: %s", c.workCalID)
 }
 
@@ -59,32 +95,19 @@ func (c *Client) DeleteAllInRange(start, end time.Time) error {
 		"start", start.Format(time.RFC3339),
 		"end", end.Format(time.RFC3339))
 
-	eventsFromGoogle, err := c.GetAllGCalEvents(start, end)
+	plan, err := c.PlanDeleteAllInRange(start, end)
 	if err != nil {
-		return fmt.Errorf("getting all events failed: %w", err)
+		return err
 	}
 
-	deletedCount := 0
-	skippedCount := 0
-
-	for _, event := range eventsFromGoogle {
-		// Only delete events that were created by calsync
-		if event.Source != nil && event.Source.Title == EventSourceTitle {
-			slog.Info("Deleting event", "summary", event.Summary, "start", event.Start.DateTime, "end", event.End.DateTime)
-			if err := c.Svc.Events.Delete(c.workCalID, event.Id).Do(); err != nil {
-				return fmt.Errorf("failed to delete event %s: %w", event.Summary, err)
-			}
-			deletedCount++
-		} else {
-			slog.Info("Skipping non-calsync event", "summary", event.Summary)
-			skippedCount++
-		}
+	if err := c.ApplyPlan(plan); err != nil {
+		return err
 	}
 
 	slog.Info("Finished deleting calsync-managed events",
-		"deleted", deletedCount,
-		"skipped", skippedCount,
-		"total", len(eventsFromGoogle))
+		"deleted", plan.Count(calendar.ActionDelete),
+		"skipped", plan.Count(calendar.ActionIgnore),
+		"total", len(plan.Changes))
 
 	return nil
 }
@@ -93,7 +116,7 @@ func (c *Client) PutEvents() error {
 	return fmt.Errorf("not implemented")
 }
 
-func (C *Client) GetEvents(_ time.Time, _ time.Time) ([]calendar.Event, error) {
+func (C *Client) GetEvents(_ context.Context, _ time.Time, _ time.Time) ([]calendar.Event, error) {
 	return nil, fmt.Errorf("not implemented")
 }
 
@@ -4,6 +4,8 @@ import (
 	"log/slog"
 
 	"calsync/calendar"
+
+	"golang.org/x/exp/slices"
 )
 
 type DuplicateEventsFinder struct {
@@ -21,7 +23,7 @@ func (d *DuplicateEventsFinder) isGCalinEvents(event *Event, events []calendar.E
 
 	_, ok := d.alreadySeen[eventHash]
 	if ok {
-		slog.Debug("Event already processed before, should be a duplicate", "summary", event.Summary, "start", event.Start.DateTime, "end", event.End.DateTime)
+		slog.Debug("Event already processed before, should be a duplicate", "summary", event.Summary, "start", eventTime(event.Start), "end", eventTime(event.End))
 		return false, -1
 	}
 
@@ -34,3 +36,21 @@ func (d *DuplicateEventsFinder) isGCalinEvents(event *Event, events []calendar.E
 
 	return false, -1
 }
+
+// findByUID returns the position of the first local event, not matched already,
+// with the same source UID as the Google event. Recurring events share the UID,
+// instances are paired in start time order.
+func findByUID(event *Event, events []calendar.Event, matched []int) int {
+	uid := event.UID()
+	if uid == "" {
+		return -1
+	}
+
+	for i, e := range events {
+		if e.UID == uid && !slices.Contains(matched, i) {
+			return i
+		}
+	}
+
+	return -1
+}
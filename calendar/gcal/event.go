@@ -18,13 +18,70 @@ func (e Event) Hash() string {
 	var buffer bytes.Buffer
 	buffer.WriteString(e.Summary)
 
-	startTime, _ := time.Parse(time.RFC3339, e.Start.DateTime)
-	endTime, _ := time.Parse(time.RFC3339, e.End.DateTime)
+	if e.IsAllDay() {
+		// Same format as calendar.Event.Hash() for all-day events
+		buffer.WriteString(e.Start.Date)
+		buffer.WriteString(e.End.Date)
+	} else {
+		startTime, _ := time.Parse(time.RFC3339, e.Start.DateTime)
+		endTime, _ := time.Parse(time.RFC3339, e.End.DateTime)
 
-	buffer.WriteString(startTime.UTC().Format(time.RFC3339))
-	buffer.WriteString(endTime.UTC().Format(time.RFC3339))
+		buffer.WriteString(startTime.UTC().Format(time.RFC3339))
+		buffer.WriteString(endTime.UTC().Format(time.RFC3339))
+	}
 	buffer.WriteString(e.Description)
+	if e.Location != "" {
+		buffer.WriteString(e.Location)
+	}
 
 	md5sum := md5.Sum(buffer.Bytes())
 	return hex.EncodeToString(md5sum[:])
 }
+
+// IsAllDay returns true when the event only has a date, without time
+func (e Event) IsAllDay() bool {
+	return e.Start != nil && e.Start.Date != ""
+}
+
+// eventTime returns the date-time, or the date for all-day events, used when logging
+func eventTime(dt *googlecalendar.EventDateTime) string {
+	if dt == nil {
+		return ""
+	}
+	if dt.Date != "" {
+		return dt.Date
+	}
+	return dt.DateTime
+}
+
+// IsManaged returns true when the event was created by calsync
+func (e Event) IsManaged() bool {
+	return e.Source != nil && e.Source.Title == EventSourceTitle
+}
+
+// SourceCalendar returns the source calendar the event was synced from, stored by calsync in
+// the private extended properties. Events synced by older versions don't have it.
+func (e Event) SourceCalendar() string {
+	if e.ExtendedProperties == nil {
+		return ""
+	}
+	return e.ExtendedProperties.Private["source"]
+}
+
+// Namespace returns the calsync namespace the event was synced in, stored by calsync in the
+// private extended properties. It's empty for the default namespace and for events synced by
+// older versions.
+func (e Event) Namespace() string {
+	if e.ExtendedProperties == nil {
+		return ""
+	}
+	return e.ExtendedProperties.Private["namespace"]
+}
+
+// UID returns the source event's UID, stored by calsync in the private extended properties
+func (e Event) UID() string {
+	if e.ExtendedProperties == nil {
+		return ""
+	}
+	return e.ExtendedProperties.Private["uid"]
+}
@@ -0,0 +1,88 @@
+package gcal
+
+import (
+	"calsync/calendar"
+	"calsync/state"
+	"log/slog"
+	"time"
+)
+
+// eventStateRetention is how long events not seen at the source anymore are remembered
+const eventStateRetention = 30 * 24 * time.Hour
+
+// eventStore returns the state of the calendar, read on first use. It's nil when
+// no state is kept, an unreadable state is started over.
+func (c *Client) eventStore() *state.Store {
+	if c.events != nil || c.eventStateFile == "" {
+		return c.events
+	}
+
+	store, err := state.Open(c.eventStateFile)
+	if err != nil {
+		slog.Warn("Ignoring unreadable event state, matching events by content", "error", err)
+		store = state.New(c.eventStateFile)
+	}
+	c.events = store
+
+	return c.events
+}
+
+// storedChange plans the sync of a source event to the Google event it was last synced to.
+// Content that differs from what was synced last was edited on Google, it's restored.
+func (c *Client) storedChange(gEvent *Event, event calendar.Event, entry state.Entry) calendar.Change {
+	gHash := gEvent.Hash()
+
+	var reason string
+	switch {
+	case gHash == event.Hash() && gEvent.Namespace() == c.cfg.Namespace:
+		change := gcalChange(calendar.ActionSkip, gEvent, reasonInSync)
+		change.Event = event
+		return change
+	case gHash == event.Hash():
+		reason = reasonAdopted
+	case gHash != entry.Hash:
+		reason = reasonEditedOnGoogle
+	default:
+		reason = reasonChanged
+	}
+
+	change := localChange(calendar.ActionUpdate, event, reason)
+	change.ID = gEvent.Id
+	return change
+}
+
+// recordSynced remembers that the source event of change is at the Google event with id
+func (c *Client) recordSynced(change calendar.Change, id string) {
+	store := c.eventStore()
+	if store == nil || id == "" {
+		return
+	}
+
+	store.Put(state.Key(change.Event), state.Entry{
+		TargetID: id,
+		Hash:     change.Event.Hash(),
+		LastSeen: time.Now(),
+	})
+}
+
+// recordDeleted forgets the Google event with id
+func (c *Client) recordDeleted(id string) {
+	if store := c.eventStore(); store != nil {
+		store.DeleteTarget(id)
+	}
+}
+
+// saveEventState persists the state, losing it only costs matching events by content again
+func (c *Client) saveEventState() {
+	store := c.eventStore()
+	if store == nil {
+		return
+	}
+
+	if n := store.Prune(time.Now().Add(-eventStateRetention)); n > 0 {
+		slog.Debug("Forgot events not seen at the source anymore", "count", n)
+	}
+	if err := store.Save(); err != nil {
+		slog.Warn("Couldn't save event state", "error", err, "file", c.eventStateFile)
+	}
+}
@@ -1,28 +1,46 @@
 package gcal
 
 import (
+	"bufio"
+	"bytes"
 	"calsync/calendar"
 	"calsync/config"
+	"calsync/state"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"golang.org/x/exp/slices"
 	"golang.org/x/oauth2"
 	googlecalendar "google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
 )
 
 func TestSyncToDest(t *testing.T) {
+	now := time.Now()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+
 	tests := []struct {
 		name           string
 		existingEvents []*googlecalendar.Event
 		localEvents    []calendar.Event
 		wantCreated    int
 		wantDeleted    int
+		wantUpdated    int
 		wantDeletedIDs []string
 	}{
 		{
@@ -145,6 +163,98 @@ func TestSyncToDest(t *testing.T) {
 			wantDeleted:    0,
 			wantDeletedIDs: []string{},
 		},
+		{
+			name: "update events with changed location in place",
+			existingEvents: []*googlecalendar.Event{
+				{
+					Id:          "existing1",
+					Summary:     "Matching Event",
+					Description: "Matching Notes",
+					Location:    "Room 1",
+					Start: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+					},
+					End: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+					},
+					Source: &googlecalendar.EventSource{
+						Title: EventSourceTitle,
+						Url:   "https://github.com/shadyabhi/calsync",
+					},
+					ExtendedProperties: &googlecalendar.EventExtendedProperties{
+						Private: map[string]string{
+							"uid": "uid1",
+						},
+					},
+				},
+			},
+			localEvents: []calendar.Event{
+				{
+					Title:    "Matching Event",
+					Notes:    "Matching Notes",
+					Location: "Room 2",
+					Start:    time.Now().Add(1 * time.Hour),
+					Stop:     time.Now().Add(2 * time.Hour),
+					UID:      "uid1",
+				},
+			},
+			wantCreated:    0,
+			wantDeleted:    0,
+			wantUpdated:    1,
+			wantDeletedIDs: []string{},
+		},
+		{
+			name: "keep matching all-day events",
+			existingEvents: []*googlecalendar.Event{
+				{
+					Id:      "existing1",
+					Summary: "PTO",
+					Start: &googlecalendar.EventDateTime{
+						Date: tomorrow.Format(calendar.DateLayout),
+					},
+					End: &googlecalendar.EventDateTime{
+						Date: tomorrow.AddDate(0, 0, 2).Format(calendar.DateLayout),
+					},
+					Source: &googlecalendar.EventSource{
+						Title: EventSourceTitle,
+						Url:   "https://github.com/shadyabhi/calsync",
+					},
+					ExtendedProperties: &googlecalendar.EventExtendedProperties{
+						Private: map[string]string{
+							"uid": "uid1",
+						},
+					},
+				},
+			},
+			localEvents: []calendar.Event{
+				{
+					Title:  "PTO",
+					Start:  tomorrow,
+					Stop:   tomorrow.AddDate(0, 0, 2),
+					UID:    "uid1",
+					AllDay: true,
+				},
+			},
+			wantCreated:    0,
+			wantDeleted:    0,
+			wantDeletedIDs: []string{},
+		},
+		{
+			name:           "create all-day events with dates",
+			existingEvents: []*googlecalendar.Event{},
+			localEvents: []calendar.Event{
+				{
+					Title:  "Holiday",
+					Start:  tomorrow,
+					Stop:   tomorrow.AddDate(0, 0, 1),
+					UID:    "uid1",
+					AllDay: true,
+				},
+			},
+			wantCreated:    1,
+			wantDeleted:    0,
+			wantDeletedIDs: []string{},
+		},
 	}
 
 	for _, tt := range tests {
@@ -178,6 +288,11 @@ func TestSyncToDest(t *testing.T) {
 				t.Errorf("Deleted events: got %d, want %d", deletedCount, tt.wantDeleted)
 			}
 
+			// Verify updated events
+			if len(mockServer.UpdatedIDs) != tt.wantUpdated {
+				t.Errorf("Updated events: got %d, want %d", len(mockServer.UpdatedIDs), tt.wantUpdated)
+			}
+
 			// Verify specific deleted IDs
 			for _, wantID := range tt.wantDeletedIDs {
 				found := false
@@ -212,127 +327,179 @@ func TestSyncToDest(t *testing.T) {
 				if event.ExtendedProperties == nil || event.ExtendedProperties.Private == nil {
 					t.Error("Event is missing extended properties")
 				}
+
+				// Verify all-day events are published as dates only
+				if (event.Start.Date != "") == (event.Start.DateTime != "") {
+					t.Errorf("Event start should have exactly one of Date/DateTime: %#v", event.Start)
+				}
 			}
 		})
 	}
 }
 
-func TestDeleteAllInRange(t *testing.T) {
-	tests := []struct {
-		name           string
-		existingEvents []*googlecalendar.Event
-		start          time.Time
-		end            time.Time
-		wantDeleted    []string
-	}{
+func TestSyncToDestUpdatesInPlace(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	start := time.Now().Add(1 * time.Hour).Truncate(time.Second)
+	mockServer.addEvent(&googlecalendar.Event{
+		Id:          "existing1",
+		Summary:     "Old title",
+		Description: "Old notes",
+		Location:    "Room 1",
+		ColorId:     "5",
+		Start:       &googlecalendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+		End:         &googlecalendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+		Source:      &googlecalendar.EventSource{Title: EventSourceTitle},
+		ExtendedProperties: &googlecalendar.EventExtendedProperties{
+			Private: map[string]string{"uid": "uid1"},
+		},
+	})
+
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+
+	err := client.SyncToDest([]calendar.Event{
 		{
-			name: "delete only calsync events in range",
-			existingEvents: []*googlecalendar.Event{
-				{
-					Id:      "calsync1",
-					Summary: "CalSync Event",
-					Start: &googlecalendar.EventDateTime{
-						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
-					},
-					End: &googlecalendar.EventDateTime{
-						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
-					},
-					Source: &googlecalendar.EventSource{
-						Title: EventSourceTitle,
-					},
-				},
-				{
-					Id:      "manual1",
-					Summary: "Manual Event",
-					Start: &googlecalendar.EventDateTime{
-						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
-					},
-					End: &googlecalendar.EventDateTime{
-						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
-					},
-				},
-			},
-			start:       time.Now(),
-			end:         time.Now().Add(24 * time.Hour),
-			wantDeleted: []string{"calsync1"},
+			Title: "New title",
+			Start: start,
+			Stop:  start.Add(2 * time.Hour),
+			UID:   "uid1",
 		},
+	})
+	if err != nil {
+		t.Fatalf("SyncToDest failed: %v", err)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			mockServer := newMockServer(t)
-			defer mockServer.Close()
+	if mockServer.CreatedCount != 0 || len(mockServer.DeletedIDs) != 0 {
+		t.Fatalf("Expected no creates/deletes, got created=%d deleted=%v", mockServer.CreatedCount, mockServer.DeletedIDs)
+	}
+	if len(mockServer.Events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(mockServer.Events))
+	}
 
-			// Add existing events
-			for _, event := range tt.existingEvents {
-				mockServer.addEvent(event)
-			}
+	got := mockServer.Events[0]
+	if got.Id != "existing1" {
+		t.Errorf("Event ID: got %s, want existing1", got.Id)
+	}
+	if got.Summary != "New title" {
+		t.Errorf("Event title: got %s, want New title", got.Summary)
+	}
+	if got.Description != "" || got.Location != "" {
+		t.Errorf("Removed notes/location must be cleared, got description=%q location=%q", got.Description, got.Location)
+	}
+	if got.End.DateTime != start.Add(2*time.Hour).Format(time.RFC3339) {
+		t.Errorf("Event end: got %s, want %s", got.End.DateTime, start.Add(2*time.Hour).Format(time.RFC3339))
+	}
+	if got.ColorId != "5" {
+		t.Errorf("Google-side fields must survive updates, got ColorId %q", got.ColorId)
+	}
+}
 
-			testConfig := newTestClientConfig(t, mockServer)
-			client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+func TestPlanSync(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
 
-			// Run delete
-			err := client.DeleteAllInRange(tt.start, tt.end)
-			if err != nil {
-				t.Fatalf("DeleteAllInRange failed: %v", err)
-			}
+	start := time.Now().Add(1 * time.Hour).Truncate(time.Second)
+	for _, event := range []*googlecalendar.Event{
+		{
+			Id:      "changed1",
+			Summary: "Old title",
+			Start:   &googlecalendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+			End:     &googlecalendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+			Source:  &googlecalendar.EventSource{Title: EventSourceTitle},
+			ExtendedProperties: &googlecalendar.EventExtendedProperties{
+				Private: map[string]string{"uid": "uid1"},
+			},
+		},
+		{
+			Id:      "stale1",
+			Summary: "Stale",
+			Start:   &googlecalendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+			End:     &googlecalendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+			Source:  &googlecalendar.EventSource{Title: EventSourceTitle},
+		},
+		{
+			Id:      "manual1",
+			Summary: "Manual",
+			Start:   &googlecalendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+			End:     &googlecalendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+		},
+	} {
+		mockServer.addEvent(event)
+	}
 
-			// Verify deleted events
-			if len(mockServer.DeletedIDs) != len(tt.wantDeleted) {
-				t.Errorf("Deleted count: got %d, want %d", len(mockServer.DeletedIDs), len(tt.wantDeleted))
-			}
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
 
-			for _, wantID := range tt.wantDeleted {
-				found := false
-				for _, deletedID := range mockServer.DeletedIDs {
-					if deletedID == wantID {
-						found = true
-						break
-					}
-				}
-				if !found {
-					t.Errorf("Expected event %s to be deleted", wantID)
-				}
-			}
-		})
+	plan, err := client.PlanSync([]calendar.Event{
+		{Title: "New title", Start: start, Stop: start.Add(time.Hour), UID: "uid1"},
+		{Title: "Brand new", Start: start, Stop: start.Add(time.Hour), UID: "uid2"},
+	})
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	want := map[calendar.Action]int{
+		calendar.ActionCreate: 1,
+		calendar.ActionUpdate: 1,
+		calendar.ActionDelete: 1,
+		calendar.ActionSkip:   0,
+		calendar.ActionIgnore: 1,
+	}
+	for action, n := range want {
+		if got := plan.Count(action); got != n {
+			t.Errorf("Plan %s count: got %d, want %d", action, got, n)
+		}
+	}
+
+	if mockServer.CreatedCount != 0 || len(mockServer.DeletedIDs) != 0 || len(mockServer.UpdatedIDs) != 0 {
+		t.Errorf("Planning must not modify the calendar, got created=%d deleted=%v updated=%v",
+			mockServer.CreatedCount, mockServer.DeletedIDs, mockServer.UpdatedIDs)
 	}
 }
 
-func TestGetAllGCalEvents(t *testing.T) {
+func TestSyncToDestNamespace(t *testing.T) {
+	start := time.Now().Add(1 * time.Hour).Truncate(time.Second)
+	newEvent := func(id, summary, namespace string) *googlecalendar.Event {
+		private := map[string]string{"uid": id}
+		if namespace != "" {
+			private["namespace"] = namespace
+		}
+		return &googlecalendar.Event{
+			Id:                 id,
+			Summary:            summary,
+			Start:              &googlecalendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+			End:                &googlecalendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+			Source:             &googlecalendar.EventSource{Title: EventSourceTitle},
+			ExtendedProperties: &googlecalendar.EventExtendedProperties{Private: private},
+		}
+	}
+
 	tests := []struct {
-		name           string
-		existingEvents []*googlecalendar.Event
-		start          time.Time
-		end            time.Time
-		wantCount      int
+		name          string
+		namespace     string
+		adoptUntagged bool
+		wantDeleted   []string
+		wantUpdated   []string
+		wantCreated   int
 	}{
 		{
-			name: "get events in range",
-			existingEvents: []*googlecalendar.Event{
-				{
-					Id:      "event1",
-					Summary: "Event 1",
-					Start: &googlecalendar.EventDateTime{
-						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
-					},
-					End: &googlecalendar.EventDateTime{
-						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
-					},
-				},
-				{
-					Id:      "event2",
-					Summary: "Event 2",
-					Start: &googlecalendar.EventDateTime{
-						DateTime: time.Now().Add(25 * time.Hour).Format(time.RFC3339),
-					},
-					End: &googlecalendar.EventDateTime{
-						DateTime: time.Now().Add(26 * time.Hour).Format(time.RFC3339),
-					},
-				},
-			},
-			start:     time.Now(),
-			end:       time.Now().Add(24 * time.Hour),
-			wantCount: 1,
+			name:        "default namespace keeps managing untagged events",
+			wantDeleted: []string{"untaggedStale"},
+		},
+		{
+			name:        "namespace only touches its own events",
+			namespace:   "alice",
+			wantDeleted: []string{"aliceStale"},
+			wantCreated: 1,
+		},
+		{
+			name:          "namespace adopts untagged events",
+			namespace:     "alice",
+			adoptUntagged: true,
+			wantDeleted:   []string{"aliceStale", "untaggedStale"},
+			wantUpdated:   []string{"untaggedInSync"},
 		},
 	}
 
@@ -341,52 +508,637 @@ func TestGetAllGCalEvents(t *testing.T) {
 			mockServer := newMockServer(t)
 			defer mockServer.Close()
 
-			// Add existing events
-			for _, event := range tt.existingEvents {
-				mockServer.addEvent(event)
-			}
+			mockServer.addEvent(newEvent("aliceStale", "Alice stale", "alice"))
+			mockServer.addEvent(newEvent("bobStale", "Bob stale", "bob"))
+			mockServer.addEvent(newEvent("untaggedStale", "Untagged stale", ""))
+			mockServer.addEvent(newEvent("untaggedInSync", "In sync", ""))
 
 			testConfig := newTestClientConfig(t, mockServer)
+			testConfig.Config.Namespace = tt.namespace
+			testConfig.Config.AdoptUntagged = tt.adoptUntagged
 			client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
 
-			// Get events
-			events, err := client.GetAllGCalEvents(tt.start, tt.end)
+			err := client.SyncToDest([]calendar.Event{
+				{Title: "In sync", Start: start, Stop: start.Add(time.Hour), UID: "untaggedInSync"},
+			})
 			if err != nil {
-				t.Fatalf("GetAllGCalEvents failed: %v", err)
+				t.Fatalf("SyncToDest failed: %v", err)
 			}
 
-			if len(events) != tt.wantCount {
-				t.Errorf("Event count: got %d, want %d", len(events), tt.wantCount)
+			slices.Sort(mockServer.DeletedIDs)
+			if !slices.Equal(mockServer.DeletedIDs, tt.wantDeleted) {
+				t.Errorf("Deleted: got %v, want %v", mockServer.DeletedIDs, tt.wantDeleted)
+			}
+			if !slices.Equal(mockServer.UpdatedIDs, tt.wantUpdated) {
+				t.Errorf("Updated: got %v, want %v", mockServer.UpdatedIDs, tt.wantUpdated)
+			}
+			if mockServer.CreatedCount != tt.wantCreated {
+				t.Errorf("Created: got %d, want %d", mockServer.CreatedCount, tt.wantCreated)
+			}
+
+			// Created and adopted events are written with the namespace
+			for _, event := range mockServer.Events {
+				if event.Summary == "In sync" && (event.Id != "untaggedInSync" || tt.adoptUntagged) {
+					if got := (Event{event}).Namespace(); got != tt.namespace {
+						t.Errorf("Namespace of %s: got %q, want %q", event.Id, got, tt.namespace)
+					}
+				}
 			}
 		})
 	}
 }
 
-func TestPublishAllEvents(t *testing.T) {
+func TestSyncToDestEventState(t *testing.T) {
 	mockServer := newMockServer(t)
 	defer mockServer.Close()
 
 	testConfig := newTestClientConfig(t, mockServer)
-	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+	stateFile := filepath.Join(t.TempDir(), "gcal-test-calendar-events.json")
+	newClient := func() *Client {
+		client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+		client.eventStateFile = stateFile
+		return client
+	}
 
-	events := []calendar.Event{
-		{
-			Title: "Test Event 1",
-			Notes: "Notes 1",
-			Start: time.Now().Add(1 * time.Hour),
-			Stop:  time.Now().Add(2 * time.Hour),
-			UID:   "uid1",
-		},
-		{
-			Title: "Test Event 2",
-			Notes: "Notes 2",
-			Start: time.Now().Add(3 * time.Hour),
-			Stop:  time.Now().Add(4 * time.Hour),
-			UID:   "uid2",
-		},
+	start := time.Now().Add(1 * time.Hour).Truncate(time.Second)
+	event := calendar.Event{Title: "Standup", Start: start, Stop: start.Add(15 * time.Minute), UID: "uid1"}
+	if err := newClient().SyncToDest([]calendar.Event{event}); err != nil {
+		t.Fatalf("SyncToDest failed: %v", err)
+	}
+	if mockServer.CreatedCount != 1 {
+		t.Fatalf("Created: got %d, want 1", mockServer.CreatedCount)
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Fatalf("Event state wasn't saved: %v", err)
 	}
 
-	err := client.PublishAllEvents(events)
+	// Edited on Google, and without the UID that would otherwise pair it with its source
+	gEvent := mockServer.Events[0]
+	gEvent.Summary = "Standup (moved)"
+	gEvent.ExtendedProperties.Private = map[string]string{}
+
+	plan, err := newClient().PlanSync([]calendar.Event{event})
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Action != calendar.ActionUpdate || plan.Changes[0].Reason != reasonEditedOnGoogle {
+		t.Fatalf("Edit on Google should be restored, got %+v", plan.Changes)
+	}
+
+	// Changed at the source, updated in place
+	event.Title = "Daily"
+	if err := newClient().SyncToDest([]calendar.Event{event}); err != nil {
+		t.Fatalf("SyncToDest failed: %v", err)
+	}
+	if mockServer.CreatedCount != 1 || len(mockServer.DeletedIDs) != 0 {
+		t.Errorf("Event should be updated in place, got created=%d deleted=%v", mockServer.CreatedCount, mockServer.DeletedIDs)
+	}
+	if !slices.Equal(mockServer.UpdatedIDs, []string{gEvent.Id}) || mockServer.Events[0].Summary != "Daily" {
+		t.Errorf("Updated: got %v with summary %q", mockServer.UpdatedIDs, mockServer.Events[0].Summary)
+	}
+
+	plan, err = newClient().PlanSync([]calendar.Event{event})
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if plan.Count(calendar.ActionSkip) != 1 || len(plan.Changes) != 1 {
+		t.Errorf("Synced event should be skipped, got %+v", plan.Changes)
+	}
+}
+
+func TestPlanSyncMovedEvent(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	testConfig := newTestClientConfig(t, mockServer)
+	stateFile := filepath.Join(t.TempDir(), "gcal-test-calendar-events.json")
+	newClient := func() *Client {
+		client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+		client.eventStateFile = stateFile
+		return client
+	}
+
+	start := time.Now().Add(1 * time.Hour).Truncate(time.Second)
+	event := calendar.Event{Title: "Standup", Start: start, Stop: start.Add(15 * time.Minute), UID: "uid1"}
+	if err := newClient().SyncToDest([]calendar.Event{event}); err != nil {
+		t.Fatalf("SyncToDest failed: %v", err)
+	}
+
+	// Edited on Google without the UID, only the state pairs it with its source
+	gEvent := mockServer.Events[0]
+	gEvent.Summary = "Standup (edited)"
+	gEvent.ExtendedProperties.Private = map[string]string{}
+
+	// Moved at the source
+	event.Start, event.Stop = event.Start.Add(-10*time.Minute), event.Stop.Add(-10*time.Minute)
+
+	plan, err := newClient().PlanSync([]calendar.Event{event})
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if len(plan.Changes) != 1 {
+		t.Fatalf("Moved event should be updated in place, got %+v", plan.Changes)
+	}
+	change := plan.Changes[0]
+	if change.Action != calendar.ActionUpdate || change.ID != gEvent.Id || change.Reason != reasonEditedOnGoogle {
+		t.Errorf("Moved event should reuse Google event %s and restore its edit, got %+v", gEvent.Id, change)
+	}
+}
+
+func TestPublishAllEventsEventState(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+	client.eventStateFile = filepath.Join(t.TempDir(), "gcal-test-calendar-events.json")
+
+	start := time.Now().Add(1 * time.Hour).Truncate(time.Second)
+	event := calendar.Event{Title: "Standup", Start: start, Stop: start.Add(15 * time.Minute), UID: "uid1"}
+	if err := client.PublishAllEvents([]calendar.Event{event}); err != nil {
+		t.Fatalf("PublishAllEvents failed: %v", err)
+	}
+
+	store, err := state.Open(client.eventStateFile)
+	if err != nil {
+		t.Fatalf("Opening event state failed: %v", err)
+	}
+	entry, ok := store.Get(state.Key(event))
+	if !ok || entry.TargetID != mockServer.Events[0].Id {
+		t.Errorf("Published event should be saved to the state, got %+v", store.Entries)
+	}
+}
+
+func TestSyncToDestEmptySource(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	mockServer.addEvent(&googlecalendar.Event{
+		Id:      "calsync1",
+		Summary: "CalSync Event",
+		Start:   &googlecalendar.EventDateTime{DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339)},
+		End:     &googlecalendar.EventDateTime{DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339)},
+		Source:  &googlecalendar.EventSource{Title: EventSourceTitle},
+	})
+
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+
+	if err := client.SyncToDest([]calendar.Event{}); err != nil {
+		t.Fatalf("SyncToDest failed: %v", err)
+	}
+
+	if len(mockServer.DeletedIDs) != 0 {
+		t.Errorf("Empty source must not delete events, deleted %v", mockServer.DeletedIDs)
+	}
+}
+
+func TestDeleteAllInRange(t *testing.T) {
+	tests := []struct {
+		name           string
+		namespace      string
+		existingEvents []*googlecalendar.Event
+		start          time.Time
+		end            time.Time
+		wantDeleted    []string
+	}{
+		{
+			name: "delete only calsync events in range",
+			existingEvents: []*googlecalendar.Event{
+				{
+					Id:      "calsync1",
+					Summary: "CalSync Event",
+					Start: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+					},
+					End: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+					},
+					Source: &googlecalendar.EventSource{
+						Title: EventSourceTitle,
+					},
+				},
+				{
+					Id:      "manual1",
+					Summary: "Manual Event",
+					Start: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+					},
+					End: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+					},
+				},
+			},
+			start:       time.Now(),
+			end:         time.Now().Add(24 * time.Hour),
+			wantDeleted: []string{"calsync1"},
+		}, {
+			name:      "delete only events of the namespace",
+			namespace: "alice",
+			existingEvents: []*googlecalendar.Event{
+				{
+					Id:      "alice1",
+					Summary: "Alice Event",
+					Start: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+					},
+					End: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+					},
+					Source: &googlecalendar.EventSource{
+						Title: EventSourceTitle,
+					},
+					ExtendedProperties: &googlecalendar.EventExtendedProperties{
+						Private: map[string]string{"namespace": "alice"},
+					},
+				},
+				{
+					Id:      "bob1",
+					Summary: "Bob Event",
+					Start: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+					},
+					End: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+					},
+					Source: &googlecalendar.EventSource{
+						Title: EventSourceTitle,
+					},
+					ExtendedProperties: &googlecalendar.EventExtendedProperties{
+						Private: map[string]string{"namespace": "bob"},
+					},
+				},
+				{
+					Id:      "untagged1",
+					Summary: "Untagged Event",
+					Start: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+					},
+					End: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+					},
+					Source: &googlecalendar.EventSource{
+						Title: EventSourceTitle,
+					},
+				},
+			},
+			start:       time.Now(),
+			end:         time.Now().Add(24 * time.Hour),
+			wantDeleted: []string{"alice1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer := newMockServer(t)
+			defer mockServer.Close()
+
+			// Add existing events
+			for _, event := range tt.existingEvents {
+				mockServer.addEvent(event)
+			}
+
+			testConfig := newTestClientConfig(t, mockServer)
+			testConfig.Config.Namespace = tt.namespace
+			client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+
+			// Run delete
+			err := client.DeleteAllInRange(tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("DeleteAllInRange failed: %v", err)
+			}
+
+			// Verify deleted events
+			if len(mockServer.DeletedIDs) != len(tt.wantDeleted) {
+				t.Errorf("Deleted count: got %d, want %d", len(mockServer.DeletedIDs), len(tt.wantDeleted))
+			}
+
+			for _, wantID := range tt.wantDeleted {
+				found := false
+				for _, deletedID := range mockServer.DeletedIDs {
+					if deletedID == wantID {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("Expected event %s to be deleted", wantID)
+				}
+			}
+		})
+	}
+}
+
+func TestGetAllGCalEvents(t *testing.T) {
+	tests := []struct {
+		name           string
+		existingEvents []*googlecalendar.Event
+		start          time.Time
+		end            time.Time
+		wantCount      int
+	}{
+		{
+			name: "get events in range",
+			existingEvents: []*googlecalendar.Event{
+				{
+					Id:      "event1",
+					Summary: "Event 1",
+					Start: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+					},
+					End: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+					},
+				},
+				{
+					Id:      "event2",
+					Summary: "Event 2",
+					Start: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(25 * time.Hour).Format(time.RFC3339),
+					},
+					End: &googlecalendar.EventDateTime{
+						DateTime: time.Now().Add(26 * time.Hour).Format(time.RFC3339),
+					},
+				},
+			},
+			start:     time.Now(),
+			end:       time.Now().Add(24 * time.Hour),
+			wantCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer := newMockServer(t)
+			defer mockServer.Close()
+
+			// Add existing events
+			for _, event := range tt.existingEvents {
+				mockServer.addEvent(event)
+			}
+
+			testConfig := newTestClientConfig(t, mockServer)
+			client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+
+			// Get events
+			events, err := client.GetAllGCalEvents(tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("GetAllGCalEvents failed: %v", err)
+			}
+
+			if len(events) != tt.wantCount {
+				t.Errorf("Event count: got %d, want %d", len(events), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestGetAllGCalEventsPaginated(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+	mockServer.PageSize = 2
+
+	for i := 0; i < 5; i++ {
+		mockServer.addEvent(&googlecalendar.Event{
+			Id:      fmt.Sprintf("event%d", i),
+			Summary: fmt.Sprintf("Event %d", i),
+			Start:   &googlecalendar.EventDateTime{DateTime: time.Now().Add(time.Duration(i+1) * time.Hour).Format(time.RFC3339)},
+			End:     &googlecalendar.EventDateTime{DateTime: time.Now().Add(time.Duration(i+2) * time.Hour).Format(time.RFC3339)},
+		})
+	}
+
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+
+	events, err := client.GetAllGCalEvents(time.Now(), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetAllGCalEvents failed: %v", err)
+	}
+
+	if len(events) != 5 {
+		t.Errorf("Event count: got %d, want %d", len(events), 5)
+	}
+	for i, event := range events {
+		if want := fmt.Sprintf("event%d", i); event.Id != want {
+			t.Errorf("Event %d: got %s, want %s", i, event.Id, want)
+		}
+	}
+	if mockServer.ListCalls != 3 {
+		t.Errorf("List calls: got %d, want %d", mockServer.ListCalls, 3)
+	}
+}
+
+func TestGetAllGCalEventsIncremental(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	start := time.Now()
+	newTestEvent := func(id string, hours int) *googlecalendar.Event {
+		return &googlecalendar.Event{
+			Id:      id,
+			Summary: id,
+			Start:   &googlecalendar.EventDateTime{DateTime: start.Add(time.Duration(hours) * time.Hour).Format(time.RFC3339)},
+			End:     &googlecalendar.EventDateTime{DateTime: start.Add(time.Duration(hours+1) * time.Hour).Format(time.RFC3339)},
+		}
+	}
+	mockServer.addEvent(newTestEvent("event1", 1))
+	mockServer.addEvent(newTestEvent("event2", 2))
+
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+	client.syncStateFile = filepath.Join(t.TempDir(), "state", "gcal.json")
+
+	getIDs := func() []string {
+		t.Helper()
+		events, err := client.GetAllGCalEvents(start, start.Add(24*time.Hour))
+		if err != nil {
+			t.Fatalf("GetAllGCalEvents failed: %v", err)
+		}
+		ids := make([]string, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.Id)
+		}
+		return ids
+	}
+
+	// First run lists everything and stores the sync token
+	if got, want := getIDs(), []string{"event1", "event2"}; !slices.Equal(got, want) {
+		t.Errorf("Full sync: got %v, want %v", got, want)
+	}
+	if mockServer.SyncTokenCalls != 0 {
+		t.Errorf("Full sync must not use a sync token, got %d calls", mockServer.SyncTokenCalls)
+	}
+
+	// Changes made on Google's side, and outside the requested window
+	if err := client.Svc.Events.Delete("test-calendar", "event1").Do(); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := client.Svc.Events.Patch("test-calendar", "event2", &googlecalendar.Event{Summary: "event2 renamed"}).Do(); err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	mockServer.addEvent(newTestEvent("event3", 3))
+	mockServer.addEvent(newTestEvent("event4", 49))
+
+	// Second run only fetches the changes
+	if got, want := getIDs(), []string{"event2", "event3"}; !slices.Equal(got, want) {
+		t.Errorf("Incremental sync: got %v, want %v", got, want)
+	}
+	if mockServer.SyncTokenCalls != 1 {
+		t.Errorf("Incremental sync calls: got %d, want %d", mockServer.SyncTokenCalls, 1)
+	}
+
+	// event4 came with the changes, but starts after the listed window
+	if stored, err := loadSyncState(client.syncStateFile); err != nil {
+		t.Fatalf("loadSyncState failed: %v", err)
+	} else if _, ok := stored.Events["event4"]; ok {
+		t.Errorf("Events after the listed window must not be stored")
+	}
+
+	events, _ := client.GetAllGCalEvents(start, start.Add(24*time.Hour))
+	if events[0].Summary != "event2 renamed" {
+		t.Errorf("Patched event: got %q, want %q", events[0].Summary, "event2 renamed")
+	}
+
+	// Expired sync tokens fall back to a full sync
+	mockServer.SyncTokenGone = true
+	listCalls := mockServer.ListCalls
+	if got, want := getIDs(), []string{"event2", "event3"}; !slices.Equal(got, want) {
+		t.Errorf("Sync after 410 Gone: got %v, want %v", got, want)
+	}
+	if got := mockServer.ListCalls - listCalls; got != 2 {
+		t.Errorf("List calls after 410 Gone: got %d, want %d", got, 2)
+	}
+
+	// A window starting before the stored one can't be served from the state
+	mockServer.SyncTokenGone = false
+	syncTokenCalls := mockServer.SyncTokenCalls
+	if _, err := client.GetAllGCalEvents(start.Add(-2*time.Hour), start.Add(24*time.Hour)); err != nil {
+		t.Fatalf("GetAllGCalEvents failed: %v", err)
+	}
+	if mockServer.SyncTokenCalls != syncTokenCalls {
+		t.Errorf("Earlier window must do a full sync, got %d sync token calls", mockServer.SyncTokenCalls-syncTokenCalls)
+	}
+
+	// A window moving forward is served incrementally, events that ended before it are dropped
+	later := start.Add(3*time.Hour + 30*time.Minute)
+	syncTokenCalls = mockServer.SyncTokenCalls
+	if _, err := client.GetAllGCalEvents(later, later.Add(24*time.Hour)); err != nil {
+		t.Fatalf("GetAllGCalEvents failed: %v", err)
+	}
+	if mockServer.SyncTokenCalls != syncTokenCalls+1 {
+		t.Errorf("Later window must use the sync token, got %d sync token calls", mockServer.SyncTokenCalls-syncTokenCalls)
+	}
+	stored, err := loadSyncState(client.syncStateFile)
+	if err != nil {
+		t.Fatalf("loadSyncState failed: %v", err)
+	}
+	ids := make([]string, 0, len(stored.Events))
+	for id := range stored.Events {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if want := []string{"event3", "event4"}; !slices.Equal(ids, want) {
+		t.Errorf("Stored events: got %v, want %v", ids, want)
+	}
+
+	// A window ending after the listed one can't be served from the state
+	syncTokenCalls = mockServer.SyncTokenCalls
+	if _, err := client.GetAllGCalEvents(start.Add(28*time.Hour), start.Add(52*time.Hour)); err != nil {
+		t.Fatalf("GetAllGCalEvents failed: %v", err)
+	}
+	if mockServer.SyncTokenCalls != syncTokenCalls {
+		t.Errorf("Later end must do a full sync, got %d sync token calls", mockServer.SyncTokenCalls-syncTokenCalls)
+	}
+}
+
+func TestGetAllGCalEventsDryRun(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	start := time.Now()
+	mockServer.addEvent(&googlecalendar.Event{
+		Id:      "event1",
+		Summary: "event1",
+		Start:   &googlecalendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+		End:     &googlecalendar.EventDateTime{DateTime: start.Add(2 * time.Hour).Format(time.RFC3339)},
+	})
+
+	testConfig := newTestClientConfig(t, mockServer)
+	stateFile := filepath.Join(t.TempDir(), "state", "gcal.json")
+	newClient := func() *Client {
+		client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+		client.syncStateFile = stateFile
+		return client
+	}
+
+	dryRun := newClient()
+	dryRun.DryRun()
+	if _, err := dryRun.GetAllGCalEvents(start, start.Add(24*time.Hour)); err != nil {
+		t.Fatalf("GetAllGCalEvents failed: %v", err)
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Fatalf("A dry run mustn't save the sync state, got %v", err)
+	}
+
+	if _, err := newClient().GetAllGCalEvents(start, start.Add(24*time.Hour)); err != nil {
+		t.Fatalf("GetAllGCalEvents failed: %v", err)
+	}
+	saved, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("Sync state wasn't saved: %v", err)
+	}
+
+	// Changes seen by a dry run are still fetched by the next sync
+	if _, err := dryRun.Svc.Events.Patch("test-calendar", "event1", &googlecalendar.Event{Summary: "event1 renamed"}).Do(); err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	events, err := dryRun.GetAllGCalEvents(start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetAllGCalEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Summary != "event1 renamed" {
+		t.Errorf("A dry run must see the changes, got %v", events)
+	}
+	if got, err := os.ReadFile(stateFile); err != nil || !bytes.Equal(got, saved) {
+		t.Errorf("A dry run mustn't change the sync state, got error %v", err)
+	}
+
+	events, err = newClient().GetAllGCalEvents(start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetAllGCalEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Summary != "event1 renamed" {
+		t.Errorf("The next sync must still get the changes, got %v", events)
+	}
+}
+
+func TestPublishAllEvents(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+
+	events := []calendar.Event{
+		{
+			Title:    "Test Event 1",
+			Notes:    "Notes 1",
+			Location: "Room 1",
+			Start:    time.Now().Add(1 * time.Hour),
+			Stop:     time.Now().Add(2 * time.Hour),
+			UID:      "uid1",
+			Calendar: "ICS Calendar: work",
+		},
+		{
+			Title: "Test Event 2",
+			Notes: "Notes 2",
+			Start: time.Now().Add(3 * time.Hour),
+			Stop:  time.Now().Add(4 * time.Hour),
+			UID:   "uid2",
+		},
+	}
+
+	err := client.PublishAllEvents(events)
 	if err != nil {
 		t.Fatalf("PublishAllEvents failed: %v", err)
 	}
@@ -403,6 +1155,324 @@ func TestPublishAllEvents(t *testing.T) {
 		if mockEvent.Description != events[i].Notes {
 			t.Errorf("Event notes: got %s, want %s", mockEvent.Description, events[i].Notes)
 		}
+		if mockEvent.Location != events[i].Location {
+			t.Errorf("Event location: got %s, want %s", mockEvent.Location, events[i].Location)
+		}
+		if got := (&Event{mockEvent}).SourceCalendar(); got != events[i].Calendar {
+			t.Errorf("Event source calendar: got %q, want %q", got, events[i].Calendar)
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    []mockFailure
+		wantErr     bool
+		wantRetries int
+		wantSleeps  []time.Duration
+	}{
+		{
+			name:        "no failures",
+			wantRetries: 0,
+		},
+		{
+			name:        "429 honors Retry-After",
+			failures:    []mockFailure{{Status: http.StatusTooManyRequests, RetryAfter: "7"}},
+			wantRetries: 1,
+			wantSleeps:  []time.Duration{7 * time.Second},
+		},
+		{
+			name: "rate limited 403 and 5xx are retried",
+			failures: []mockFailure{
+				{Status: http.StatusForbidden, Reason: "rateLimitExceeded"},
+				{Status: http.StatusServiceUnavailable},
+			},
+			wantRetries: 2,
+		},
+		{
+			name:        "other 403s fail right away",
+			failures:    []mockFailure{{Status: http.StatusForbidden, Reason: "forbidden"}},
+			wantErr:     true,
+			wantRetries: 0,
+		},
+		{
+			name: "gives up after max retries",
+			failures: []mockFailure{
+				{Status: http.StatusInternalServerError},
+				{Status: http.StatusInternalServerError},
+				{Status: http.StatusInternalServerError},
+			},
+			wantErr:     true,
+			wantRetries: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer := newMockServer(t)
+			defer mockServer.Close()
+			mockServer.Failures = tt.failures
+
+			retry := newRetryTransport(http.DefaultTransport, 1000, 2)
+			sleeps := make([]time.Duration, 0)
+			retry.sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			httpClient := &http.Client{Transport: retry}
+			svc, err := googlecalendar.NewService(context.Background(),
+				option.WithHTTPClient(httpClient),
+				option.WithEndpoint(mockServer.URL),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create calendar service: %v", err)
+			}
+			client := newTestClient(svc, httpClient, config.Google{Id: "test-calendar"})
+			client.retry = retry
+
+			err = client.PublishAllEvents([]calendar.Event{{
+				Title: "Retried Event",
+				Start: time.Now().Add(1 * time.Hour),
+				Stop:  time.Now().Add(2 * time.Hour),
+				UID:   "retried",
+			}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PublishAllEvents() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := client.Retries(); got != tt.wantRetries {
+				t.Errorf("Retries: got %d, want %d", got, tt.wantRetries)
+			}
+			if len(sleeps) != tt.wantRetries {
+				t.Errorf("Sleeps: got %v, want %d", sleeps, tt.wantRetries)
+			}
+			for i, want := range tt.wantSleeps {
+				if sleeps[i] != want {
+					t.Errorf("Sleep %d: got %v, want %v", i, sleeps[i], want)
+				}
+			}
+			for i, got := range sleeps {
+				if tt.wantSleeps == nil && (got < retryBaseDelay<<i/2 || got > retryBaseDelay<<i) {
+					t.Errorf("Backoff %d: got %v, want between %v and %v", i, got, retryBaseDelay<<i/2, retryBaseDelay<<i)
+				}
+			}
+
+			// The request body must be sent again on each attempt
+			if !tt.wantErr && (len(mockServer.Events) != 1 || mockServer.Events[0].Summary != "Retried Event") {
+				t.Errorf("Created events: got %v, want the retried event", mockServer.Events)
+			}
+		})
+	}
+}
+
+func TestApplyPlanBatched(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+
+	plan := calendar.Plan{Calendar: client.String()}
+	for i := 0; i < 120; i++ {
+		event := calendar.Event{
+			Title: fmt.Sprintf("Event %d", i),
+			Start: time.Now().Add(time.Duration(i+1) * time.Hour),
+			Stop:  time.Now().Add(time.Duration(i+2) * time.Hour),
+			UID:   fmt.Sprintf("uid%d", i),
+		}
+		plan.Changes = append(plan.Changes, localChange(calendar.ActionCreate, event, reasonNew))
+	}
+
+	if err := client.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+
+	if !slices.Equal(mockServer.BatchSizes, []int{50, 50, 20}) {
+		t.Errorf("Batch sizes: got %v, want %v", mockServer.BatchSizes, []int{50, 50, 20})
+	}
+	if mockServer.CreatedCount != 120 {
+		t.Errorf("Created count: got %d, want %d", mockServer.CreatedCount, 120)
+	}
+}
+
+func TestApplyPlanBatchFailures(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	for _, id := range []string{"stale", "changed"} {
+		mockServer.addEvent(&googlecalendar.Event{
+			Id:      id,
+			Summary: id,
+			Start:   &googlecalendar.EventDateTime{DateTime: time.Now().Add(1 * time.Hour).Format(time.RFC3339)},
+			End:     &googlecalendar.EventDateTime{DateTime: time.Now().Add(2 * time.Hour).Format(time.RFC3339)},
+			Source:  &googlecalendar.EventSource{Title: EventSourceTitle},
+		})
+	}
+	mockServer.ItemFailures = map[string][]mockFailure{
+		"Invalid Event": {{Status: http.StatusBadRequest, Reason: "invalid"}},
+		"Busy Event":    {{Status: http.StatusForbidden, Reason: "rateLimitExceeded"}},
+	}
+
+	retry := newRetryTransport(http.DefaultTransport, 1000, 2)
+	retry.sleep = func(context.Context, time.Duration) error { return nil }
+
+	testConfig := newTestClientConfig(t, mockServer)
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+	client.retry = retry
+
+	newEvent := func(title string) calendar.Event {
+		return calendar.Event{
+			Title: title,
+			Start: time.Now().Add(3 * time.Hour),
+			Stop:  time.Now().Add(4 * time.Hour),
+			UID:   title,
+		}
+	}
+	update := localChange(calendar.ActionUpdate, newEvent("Changed Event"), reasonChanged)
+	update.ID = "changed"
+	plan := calendar.Plan{
+		Calendar: client.String(),
+		Changes: []calendar.Change{
+			localChange(calendar.ActionCreate, newEvent("Valid Event"), reasonNew),
+			localChange(calendar.ActionCreate, newEvent("Invalid Event"), reasonNew),
+			localChange(calendar.ActionCreate, newEvent("Busy Event"), reasonNew),
+			update,
+			{Action: calendar.ActionDelete, Title: "stale", ID: "stale", Reason: reasonStale},
+			{Action: calendar.ActionSkip, Title: "skipped", ID: "skipped", Reason: reasonInSync},
+		},
+	}
+
+	err := client.ApplyPlan(plan)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("ApplyPlan error: got %v, want a *BatchError", err)
+	}
+	if batchErr.Total != 5 {
+		t.Errorf("Total: got %d, want %d", batchErr.Total, 5)
+	}
+	if len(batchErr.Failures) != 1 {
+		t.Fatalf("Failures: got %v, want 1", batchErr.Failures)
+	}
+	if failure := batchErr.Failures[0]; failure.Change.Event.Title != "Invalid Event" || failure.Status != http.StatusBadRequest {
+		t.Errorf("Failure: got %q with status %d, want %q with status %d", failure.Change.Event.Title, failure.Status, "Invalid Event", http.StatusBadRequest)
+	}
+	if events := batchErr.Events(); len(events) != 1 || events[0].UID != "Invalid Event" {
+		t.Errorf("Failed events: got %v, want the invalid event", events)
+	}
+
+	// Everything else is applied, the rate limited item after a retry
+	if mockServer.CreatedCount != 2 {
+		t.Errorf("Created count: got %d, want %d", mockServer.CreatedCount, 2)
+	}
+	if !slices.Equal(mockServer.UpdatedIDs, []string{"changed"}) {
+		t.Errorf("Updated IDs: got %v, want %v", mockServer.UpdatedIDs, []string{"changed"})
+	}
+	if !slices.Equal(mockServer.DeletedIDs, []string{"stale"}) {
+		t.Errorf("Deleted IDs: got %v, want %v", mockServer.DeletedIDs, []string{"stale"})
+	}
+	if !slices.Equal(mockServer.BatchSizes, []int{5, 1}) {
+		t.Errorf("Batch sizes: got %v, want %v", mockServer.BatchSizes, []int{5, 1})
+	}
+	if client.Retries() != 1 {
+		t.Errorf("Retries: got %d, want %d", client.Retries(), 1)
+	}
+}
+
+func TestApplyPlanBatchRateLimit(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+	mockServer.ItemFailures = map[string][]mockFailure{
+		"Event 7": {{Status: http.StatusTooManyRequests, Reason: "rateLimitExceeded", RetryAfter: "7"}},
+	}
+
+	// At 10 per second, charging each item would take about 12 seconds
+	retry := newRetryTransport(http.DefaultTransport, 10, 2)
+	sleeps := make([]time.Duration, 0)
+	retry.sleep = func(_ context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+
+	httpClient := &http.Client{Transport: retry}
+	svc, err := googlecalendar.NewService(context.Background(),
+		option.WithHTTPClient(httpClient),
+		option.WithEndpoint(mockServer.URL),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create calendar service: %v", err)
+	}
+	client := newTestClient(svc, httpClient, config.Google{Id: "test-calendar"})
+	client.retry = retry
+
+	plan := calendar.Plan{Calendar: client.String()}
+	for i := 0; i < 120; i++ {
+		event := calendar.Event{
+			Title: fmt.Sprintf("Event %d", i),
+			Start: time.Now().Add(time.Duration(i+1) * time.Hour),
+			Stop:  time.Now().Add(time.Duration(i+2) * time.Hour),
+			UID:   fmt.Sprintf("uid%d", i),
+		}
+		plan.Changes = append(plan.Changes, localChange(calendar.ActionCreate, event, reasonNew))
+	}
+
+	started := time.Now()
+	if err := client.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("ApplyPlan took %v, a batch must be charged once against the rate limit", elapsed)
+	}
+
+	if mockServer.CreatedCount != 120 {
+		t.Errorf("Created count: got %d, want %d", mockServer.CreatedCount, 120)
+	}
+	if !slices.Equal(mockServer.BatchSizes, []int{50, 1, 50, 20}) {
+		t.Errorf("Batch sizes: got %v, want %v", mockServer.BatchSizes, []int{50, 1, 50, 20})
+	}
+	if !slices.Equal(sleeps, []time.Duration{7 * time.Second}) {
+		t.Errorf("Sleeps: got %v, want the item's Retry-After of 7s", sleeps)
+	}
+}
+
+func TestPublishAllEventsWithAttendees(t *testing.T) {
+	mockServer := newMockServer(t)
+	defer mockServer.Close()
+
+	testConfig := newTestClientConfig(t, mockServer)
+	testConfig.Config.ShowAttendees = true
+	client := newTestClient(testConfig.Service, testConfig.HTTPClient, testConfig.Config)
+
+	events := []calendar.Event{
+		{
+			Title:     "Planning",
+			Notes:     "Agenda",
+			Start:     time.Now().Add(1 * time.Hour),
+			Stop:      time.Now().Add(2 * time.Hour),
+			UID:       "uid1",
+			Organizer: &calendar.Attendee{Name: "Jane Doe", Email: "jane@example.com"},
+			Attendees: []calendar.Attendee{
+				{Name: "John Doe", Email: "john@example.com", Status: "ACCEPTED"},
+				{Email: "me@example.com", Status: "NEEDS-ACTION"},
+			},
+		},
+	}
+
+	if err := client.PublishAllEvents(events); err != nil {
+		t.Fatalf("PublishAllEvents failed: %v", err)
+	}
+
+	want := "Agenda\n\nOrganizer: Jane Doe <jane@example.com>\nAttendees:\n- John Doe <john@example.com> (ACCEPTED)\n- me@example.com (NEEDS-ACTION)"
+	if got := mockServer.Events[0].Description; got != want {
+		t.Errorf("Event description: got %q, want %q", got, want)
+	}
+	if len(mockServer.Events[0].Attendees) != 0 {
+		t.Errorf("Event attendees must not be set, got %v", mockServer.Events[0].Attendees)
+	}
+	if events[0].Notes != "Agenda" {
+		t.Errorf("Source event notes must not be modified, got %q", events[0].Notes)
 	}
 }
 
@@ -501,14 +1571,39 @@ type mockServer struct {
 	*httptest.Server
 	Events       []*googlecalendar.Event
 	DeletedIDs   []string
+	UpdatedIDs   []string
 	CreatedCount int
-	t            *testing.T
+	// PageSize splits list responses in pages like the API does, all events are returned at once when 0
+	PageSize int
+	// ListCalls counts requests to the events list endpoint
+	ListCalls int
+	// SyncTokenCalls counts list requests made with a sync token
+	SyncTokenCalls int
+	// SyncTokenGone answers list requests made with a sync token with 410 Gone
+	SyncTokenGone bool
+	// Failures are answered, in order, before requests are handled again
+	Failures []mockFailure
+	// ItemFailures are answered, in order, to creates and updates of events with that summary
+	ItemFailures map[string][]mockFailure
+	// BatchSizes has the number of requests of each batch
+	BatchSizes []int
+	// changes logs every added, created, patched and deleted event, sync tokens are offsets in it
+	changes []*googlecalendar.Event
+	t       *testing.T
+}
+
+// mockFailure is an API error, Reason is set in the error body like Google does for 403s
+type mockFailure struct {
+	Status     int
+	Reason     string
+	RetryAfter string
 }
 
 func newMockServer(t *testing.T) *mockServer {
 	m := &mockServer{
 		Events:     []*googlecalendar.Event{},
 		DeletedIDs: []string{},
+		UpdatedIDs: []string{},
 		t:          t,
 	}
 
@@ -526,43 +1621,154 @@ func newMockServer(t *testing.T) *mockServer {
 
 	// Mock individual event operations
 	mux.HandleFunc("/calendars/test-calendar/events/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "DELETE" {
+		switch r.Method {
+		case "DELETE":
 			m.handleDeleteEvent(w, r)
+		case "PATCH":
+			m.handlePatchEvent(w, r)
 		}
 	})
 
+	// Mock batch endpoint, each part is handled like a single request
+	mux.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		m.handleBatch(w, r, mux)
+	})
+
 	// Mock calendar list endpoint
 	mux.HandleFunc("/users/me/calendarList", func(w http.ResponseWriter, r *http.Request) {
 		m.handleCalendarList(w, r)
 	})
 
-	m.Server = httptest.NewServer(mux)
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(m.Failures) == 0 {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		failure := m.Failures[0]
+		m.Failures = m.Failures[1:]
+		failure.write(w)
+	}))
 	return m
 }
 
+func (f mockFailure) write(w http.ResponseWriter) {
+	if f.RetryAfter != "" {
+		w.Header().Set("Retry-After", f.RetryAfter)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(f.Status)
+	fmt.Fprintf(w, `{"error": {"code": %d, "message": "mock failure", "errors": [{"reason": %q}]}}`, f.Status, f.Reason)
+}
+
+// itemFailure pops the next failure of events with summary, false when there's none
+func (m *mockServer) itemFailure(summary string) (mockFailure, bool) {
+	failures := m.ItemFailures[summary]
+	if len(failures) == 0 {
+		return mockFailure{}, false
+	}
+	m.ItemFailures[summary] = failures[1:]
+	return failures[0], true
+}
+
+func (m *mockServer) handleBatch(w http.ResponseWriter, r *http.Request, handler http.Handler) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	size := 0
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		size++
+
+		itemReq, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, itemReq.WithContext(r.Context()))
+
+		contentID := strings.Trim(part.Header.Get("Content-Id"), "<>")
+		respPart, _ := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-Id":   {"<response-" + contentID + ">"},
+		})
+		if err := rec.Result().Write(respPart); err != nil {
+			m.t.Errorf("Failed to write batch response: %v", err)
+		}
+	}
+	writer.Close()
+	m.BatchSizes = append(m.BatchSizes, size)
+
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	_, _ = w.Write(body.Bytes())
+}
+
 func (m *mockServer) handleListEvents(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	timeMin := query.Get("timeMin")
 	timeMax := query.Get("timeMax")
 
+	m.ListCalls++
+
+	if syncToken := query.Get("syncToken"); syncToken != "" {
+		m.handleListChanges(w, r, syncToken)
+		return
+	}
+
 	var filteredEvents []*googlecalendar.Event
 
 	for _, event := range m.Events {
-		if event.Start == nil || event.Start.DateTime == "" {
+		if event.Start == nil || (event.Start.DateTime == "" && event.Start.Date == "") {
 			continue
 		}
 
-		eventTime, _ := time.Parse(time.RFC3339, event.Start.DateTime)
+		startTime, _ := time.Parse(time.RFC3339, event.Start.DateTime)
+		if event.Start.Date != "" {
+			startTime, _ = time.ParseInLocation(calendar.DateLayout, event.Start.Date, time.Local)
+		}
 		minTime, _ := time.Parse(time.RFC3339, timeMin)
 		maxTime, _ := time.Parse(time.RFC3339, timeMax)
 
-		if !eventTime.Before(minTime) && !eventTime.After(maxTime) {
+		if !startTime.Before(minTime) && (timeMax == "" || !startTime.After(maxTime)) {
 			filteredEvents = append(filteredEvents, event)
 		}
 	}
 
 	response := &googlecalendar.Events{
-		Items: filteredEvents,
+		Items:         filteredEvents,
+		NextSyncToken: m.syncToken(),
+	}
+
+	if m.PageSize > 0 {
+		offset := 0
+		if token := query.Get("pageToken"); token != "" {
+			var err error
+			if offset, err = strconv.Atoi(token); err != nil {
+				http.Error(w, "invalid pageToken", http.StatusBadRequest)
+				return
+			}
+		}
+		end := min(offset+m.PageSize, len(filteredEvents))
+		response.Items = filteredEvents[offset:end]
+		if end < len(filteredEvents) {
+			// Like the API, the sync token only comes with the last page
+			response.NextPageToken = strconv.Itoa(end)
+			response.NextSyncToken = ""
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -572,6 +1778,55 @@ func (m *mockServer) handleListEvents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleListChanges returns events changed since syncToken, deleted events are cancelled
+func (m *mockServer) handleListChanges(w http.ResponseWriter, r *http.Request, syncToken string) {
+	m.SyncTokenCalls++
+
+	query := r.URL.Query()
+	for _, param := range []string{"timeMin", "timeMax", "orderBy"} {
+		if query.Get(param) != "" {
+			m.t.Errorf("%s can't be combined with syncToken", param)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if m.SyncTokenGone {
+		http.Error(w, "Sync token is no longer valid, a full sync is required.", http.StatusGone)
+		return
+	}
+
+	offset, err := strconv.Atoi(strings.TrimPrefix(syncToken, "sync-"))
+	if err != nil || offset > len(m.changes) {
+		http.Error(w, "invalid syncToken", http.StatusBadRequest)
+		return
+	}
+
+	// Only the latest version of each changed event is returned
+	latest := make(map[string]*googlecalendar.Event)
+	order := make([]string, 0)
+	for _, change := range m.changes[offset:] {
+		if _, ok := latest[change.Id]; !ok {
+			order = append(order, change.Id)
+		}
+		latest[change.Id] = change
+	}
+
+	response := &googlecalendar.Events{NextSyncToken: m.syncToken()}
+	for _, id := range order {
+		response.Items = append(response.Items, latest[id])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		m.t.Errorf("Failed to encode response: %v", err)
+	}
+}
+
+func (m *mockServer) syncToken() string {
+	return fmt.Sprintf("sync-%d", len(m.changes))
+}
+
 func (m *mockServer) handleCreateEvent(w http.ResponseWriter, r *http.Request) {
 	var event googlecalendar.Event
 	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
@@ -579,9 +1834,15 @@ func (m *mockServer) handleCreateEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if failure, ok := m.itemFailure(event.Summary); ok {
+		failure.write(w)
+		return
+	}
+
 	event.Id = fmt.Sprintf("event-%d", m.CreatedCount)
 	m.CreatedCount++
 	m.Events = append(m.Events, &event)
+	m.changes = append(m.changes, &event)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(&event); err != nil {
@@ -596,6 +1857,7 @@ func (m *mockServer) handleDeleteEvent(w http.ResponseWriter, r *http.Request) {
 	eventID := path[len("/calendars/test-calendar/events/"):]
 
 	m.DeletedIDs = append(m.DeletedIDs, eventID)
+	m.changes = append(m.changes, &googlecalendar.Event{Id: eventID, Status: "cancelled"})
 
 	// Remove from events list
 	for i, event := range m.Events {
@@ -608,6 +1870,55 @@ func (m *mockServer) handleDeleteEvent(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (m *mockServer) handlePatchEvent(w http.ResponseWriter, r *http.Request) {
+	eventID := r.URL.Path[len("/calendars/test-calendar/events/"):]
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var summary string
+	_ = json.Unmarshal(patch["summary"], &summary)
+	if failure, ok := m.itemFailure(summary); ok {
+		failure.write(w)
+		return
+	}
+
+	for i, event := range m.Events {
+		if event.Id != eventID {
+			continue
+		}
+
+		// Patch semantics: only top-level fields present in the request are replaced
+		existing, _ := json.Marshal(event)
+		var merged map[string]json.RawMessage
+		_ = json.Unmarshal(existing, &merged)
+		for k, v := range patch {
+			merged[k] = v
+		}
+		body, _ := json.Marshal(merged)
+
+		var updated googlecalendar.Event
+		if err := json.Unmarshal(body, &updated); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.Events[i] = &updated
+		m.UpdatedIDs = append(m.UpdatedIDs, eventID)
+		m.changes = append(m.changes, &updated)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&updated); err != nil {
+			m.t.Errorf("Failed to encode event: %v", err)
+		}
+		return
+	}
+
+	http.Error(w, "Not Found", http.StatusNotFound)
+}
+
 func (m *mockServer) handleCalendarList(w http.ResponseWriter, _ *http.Request) {
 	response := &googlecalendar.CalendarList{
 		Items: []*googlecalendar.CalendarListEntry{
@@ -627,4 +1938,5 @@ func (m *mockServer) handleCalendarList(w http.ResponseWriter, _ *http.Request)
 
 func (m *mockServer) addEvent(event *googlecalendar.Event) {
 	m.Events = append(m.Events, event)
+	m.changes = append(m.changes, event)
 }
@@ -0,0 +1,226 @@
+package gcal
+
+import (
+	"calsync/calendar"
+	"calsync/state"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	reasonInSync         = "already synced"
+	reasonNotManaged     = "not calsync managed"
+	reasonChanged        = "changed at source"
+	reasonStale          = "not at source anymore"
+	reasonNew            = "new at source"
+	reasonDeleteAll      = "calsync managed"
+	reasonNamespace      = "other calsync namespace"
+	reasonAdopted        = "adopted into namespace"
+	reasonEditedOnGoogle = "edited on Google"
+)
+
+// PlanSync computes what SyncToDest would do, without modifying Google Calendar
+func (c *Client) PlanSync(calEvents []calendar.Event) (calendar.Plan, error) {
+	plan := calendar.Plan{Calendar: c.String()}
+
+	if c.cfg.ShowAttendees {
+		calEvents = withAttendees(calEvents)
+	}
+
+	// Without events there's no range to look at, and nothing must be deleted
+	if len(calEvents) == 0 {
+		slog.Warn("No events to sync, skipping to avoid deleting calsync events", "calendar", c.String())
+		return plan, nil
+	}
+
+	calendar.Events(calEvents).SortStartTime()
+
+	eventsFromGoogle, err := c.GetAllGCalEvents(calEvents[0].Start, calEvents[len(calEvents)-1].Stop)
+	if err != nil {
+		return plan, fmt.Errorf("Getting all events failed: %w", err)
+	}
+
+	slog.Info("Planning sync of all events", "total_local_events", len(calEvents), "total_gcal_events", len(eventsFromGoogle))
+
+	foundIndicesCalEvents := make([]int, 0)
+	foundGCalEvents := make(map[string]bool)
+	// Events synced before are found by the Google event ID they were synced to
+	if store := c.eventStore(); store != nil {
+		byID := make(map[string]*Event, len(eventsFromGoogle))
+		for _, event := range eventsFromGoogle {
+			byID[event.Id] = event
+		}
+
+		for i, event := range calEvents {
+			entry, ok := store.Get(state.Key(event))
+			if !ok {
+				continue
+			}
+			gEvent, ok := byID[entry.TargetID]
+			if !ok || foundGCalEvents[gEvent.Id] || !gEvent.IsManaged() || !c.inNamespace(gEvent) {
+				continue
+			}
+
+			plan.Changes = append(plan.Changes, c.storedChange(gEvent, event, entry))
+			foundIndicesCalEvents = append(foundIndicesCalEvents, i)
+			foundGCalEvents[gEvent.Id] = true
+		}
+	}
+
+	dupFinder := newDuplicateEventsFinder()
+	notFoundGCalEvents := make([]*Event, 0)
+	// Events already created, skip them
+	for _, event := range eventsFromGoogle {
+		if foundGCalEvents[event.Id] {
+			continue
+		}
+
+		// Synced by another calsync setup sharing the calendar, leave it alone!
+		if event.IsManaged() && !c.inNamespace(event) {
+			plan.Changes = append(plan.Changes, gcalChange(calendar.ActionIgnore, event, reasonNamespace))
+			continue
+		}
+
+		exists, position := dupFinder.isGCalinEvents(event, calEvents)
+		// The local event already has its Google event, this one is a duplicate
+		exists = exists && !slices.Contains(foundIndicesCalEvents, position)
+		if exists && event.IsManaged() && event.Namespace() != c.cfg.Namespace {
+			// Adopted untagged event, in sync but the namespace must be written
+			change := localChange(calendar.ActionUpdate, calEvents[position], reasonAdopted)
+			change.ID = event.Id
+			plan.Changes = append(plan.Changes, change)
+			foundIndicesCalEvents = append(foundIndicesCalEvents, position)
+			continue
+		}
+		if exists {
+			change := gcalChange(calendar.ActionSkip, event, reasonInSync)
+			change.Event = calEvents[position]
+			plan.Changes = append(plan.Changes, change)
+			foundIndicesCalEvents = append(foundIndicesCalEvents, position)
+			continue
+		}
+		notFoundGCalEvents = append(notFoundGCalEvents, event)
+	}
+
+	// Update changed events, clean up stale events at Google Calendar
+	for _, event := range notFoundGCalEvents {
+		if !event.IsManaged() {
+			// Manually created event, not via calsync, leave it alone!
+			plan.Changes = append(plan.Changes, gcalChange(calendar.ActionIgnore, event, reasonNotManaged))
+			continue
+		}
+
+		// Same source event, but its content changed
+		if position := findByUID(event, calEvents, foundIndicesCalEvents); position != -1 {
+			change := localChange(calendar.ActionUpdate, calEvents[position], reasonChanged)
+			change.ID = event.Id
+			plan.Changes = append(plan.Changes, change)
+			foundIndicesCalEvents = append(foundIndicesCalEvents, position)
+			continue
+		}
+
+		// Exists in Google, but not local calendar, time to delete
+		plan.Changes = append(plan.Changes, gcalChange(calendar.ActionDelete, event, reasonStale))
+	}
+
+	// Create new events, as needed
+	for i, event := range calEvents {
+		if slices.Contains(foundIndicesCalEvents, i) {
+			// Event already exists, skip it
+			continue
+		}
+		plan.Changes = append(plan.Changes, localChange(calendar.ActionCreate, event, reasonNew))
+	}
+
+	return plan, nil
+}
+
+// PlanDeleteAll computes what DeleteAll would remove, without modifying Google Calendar
+func (c *Client) PlanDeleteAll(nDays int) (calendar.Plan, error) {
+	now := time.Now()
+	start := now.AddDate(0, 0, -1)
+	end := now.AddDate(0, 0, nDays)
+	return c.PlanDeleteAllInRange(start, end)
+}
+
+// PlanDeleteAllInRange computes what DeleteAllInRange would remove, without modifying Google Calendar
+func (c *Client) PlanDeleteAllInRange(start, end time.Time) (calendar.Plan, error) {
+	plan := calendar.Plan{Calendar: c.String()}
+
+	eventsFromGoogle, err := c.GetAllGCalEvents(start, end)
+	if err != nil {
+		return plan, fmt.Errorf("getting all events failed: %w", err)
+	}
+
+	for _, event := range eventsFromGoogle {
+		// Only delete events that were created by calsync, in this namespace
+		switch {
+		case !event.IsManaged():
+			plan.Changes = append(plan.Changes, gcalChange(calendar.ActionIgnore, event, reasonNotManaged))
+		case !c.inNamespace(event):
+			plan.Changes = append(plan.Changes, gcalChange(calendar.ActionIgnore, event, reasonNamespace))
+		default:
+			plan.Changes = append(plan.Changes, gcalChange(calendar.ActionDelete, event, reasonDeleteAll))
+		}
+	}
+
+	return plan, nil
+}
+
+// ApplyPlan makes the changes in the plan, batched. Changes that failed are returned
+// in a *BatchError, all others are applied.
+func (c *Client) ApplyPlan(plan calendar.Plan) error {
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case calendar.ActionSkip:
+			slog.Info("Skipping", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+			c.recordSynced(change, change.ID)
+		case calendar.ActionIgnore, calendar.ActionPreserve:
+			slog.Info("Skipping", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+		case calendar.ActionUpdate:
+			slog.Info("Changed, updating", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+		case calendar.ActionDelete:
+			slog.Info("Deleting", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+		}
+	}
+
+	// Applied changes are recorded as their batches succeed
+	defer c.saveEventState()
+
+	return c.applyBatched(plan.Changes)
+}
+
+// inNamespace returns true when the calsync managed event belongs to the configured namespace,
+// untagged events belong to the default namespace unless adopted
+func (c *Client) inNamespace(event *Event) bool {
+	namespace := event.Namespace()
+	return namespace == c.cfg.Namespace || (namespace == "" && c.cfg.AdoptUntagged)
+}
+
+// gcalChange describes a change to an event that exists in Google Calendar
+func gcalChange(action calendar.Action, event *Event, reason string) calendar.Change {
+	return calendar.Change{
+		Action: action,
+		Title:  event.Summary,
+		Start:  eventTime(event.Start),
+		End:    eventTime(event.End),
+		ID:     event.Id,
+		Reason: reason,
+		Source: event.SourceCalendar(),
+	}
+}
+
+// localChange describes a change that writes a source event to Google Calendar
+func localChange(action calendar.Action, event calendar.Event, reason string) calendar.Change {
+	return calendar.Change{
+		Action: action,
+		Title:  event.Title,
+		Start:  eventTime(eventDateTime(event, event.Start)),
+		End:    eventTime(eventDateTime(event, event.Stop)),
+		Reason: reason,
+		Event:  event,
+	}
+}
@@ -5,126 +5,198 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"net/http"
 	"strings"
 	"time"
 
-	"golang.org/x/exp/slices"
 	"golang.org/x/oauth2"
 	googlecalendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 )
 
 const EventSourceTitle = "calsync"
 
 // SyncToDest will sync all events to Google Calendar
+// - If event is present in Google Calendar with the same content, it is left alone
+// - If event is present in Google Calendar with the same source UID but different content, it is updated in place
 // - If event is present in Google Calendar but not in local calendar, it will be deleted
 func (c *Client) SyncToDest(calEvents []calendar.Event) error {
 	start := time.Now()
 
-	calendar.Events(calEvents).SortStartTime()
-
-	eventsFromGoogle, err := c.GetAllGCalEvents(calEvents[0].Start, calEvents[len(calEvents)-1].Stop)
+	plan, err := c.PlanSync(calEvents)
 	if err != nil {
-		return fmt.Errorf("Getting all events failed: %w", err)
-	}
-
-	slog.Info("Starting syncing all events", "total_local_events", len(calEvents), "total_gcal_events", len(eventsFromGoogle))
-
-	dupFinder := newDuplicateEventsFinder()
-	foundIndicesCalEvents := make([]int, 0)
-	// Clean up stale events at Google Calendar
-	for _, event := range eventsFromGoogle {
-		// Events already created, skip them
-		exists, position := dupFinder.isGCalinEvents(event, calEvents)
-		if exists {
-			slog.Info("Already synced", "summary", event.Summary, "start", event.Start.DateTime, "end", event.End.DateTime)
-			foundIndicesCalEvents = append(foundIndicesCalEvents, position)
-			continue
-		}
-
-		// Exists in Google, but not local calendar, time to delete
-		if event.Source != nil && event.Source.Title == EventSourceTitle {
-			slog.Info("Stale, deleting", "summary", event.Summary, "start", event.Start.DateTime, "end", event.End.DateTime)
-			if err := c.Svc.Events.Delete(c.workCalID, event.Id).Do(); err != nil {
-				return fmt.Errorf("Cleanup up existing event failed: %w", err)
-			}
-		} else {
-			// Manually created event, not via calsync, leave it alone!
-			slog.Info("Skipped deletion: this is not calsync managed", "summary", event.Summary, "start", event.Start.DateTime, "end", event.End.DateTime)
-		}
+		return err
 	}
 
-	// Create new events, as needed
-	for i, event := range calEvents {
-		if slices.Contains(foundIndicesCalEvents, i) {
-			// Event already exists, skip it
-			continue
-		}
-		if err := c.publishEvent(event); err != nil {
-			return fmt.Errorf("publishing event: %s ,%w", event, err)
-		}
+	if err := c.ApplyPlan(plan); err != nil {
+		return err
 	}
 
-	slog.Info("Finished syncing all events to Google", "duration", time.Since(start))
+	slog.Info("Finished syncing all events to Google", "duration", time.Since(start), "retries", c.Retries())
 
 	return nil
 }
 
+// errSyncTokenExpired is returned when Google no longer accepts the sync token (410 Gone)
+var errSyncTokenExpired = errors.New("sync token expired")
+
 func (c *Client) GetAllGCalEvents(start time.Time, end time.Time) ([]*Event, error) {
 	slog.Info("Start getting all events...")
 
-	gEvents, err := c.Svc.Events.List(c.workCalID).
+	if c.syncStateFile != "" {
+		return c.getEventsIncremental(start, end)
+	}
+
+	items, _, err := c.listEvents(c.Svc.Events.List(c.workCalID).
 		ShowDeleted(false).
 		SingleEvents(true).
 		// 2500 is the max possible from API
 		MaxResults(2500).
 		TimeMin(start.Format(time.RFC3339)).
 		TimeMax(end.Format(time.RFC3339)).
-		OrderBy("startTime").
-		Do()
+		OrderBy("startTime"))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, 0, len(items))
+	for _, item := range items {
+		events = append(events, &Event{item})
+	}
+
+	return events, nil
+}
+
+// getEventsIncremental only fetches events changed since the last run, merged into the
+// local copy kept in the sync state. Without a usable sync token, events are listed from
+// start for twice the window, so that the token stays usable while the window moves forward.
+func (c *Client) getEventsIncremental(start time.Time, end time.Time) ([]*Event, error) {
+	state, err := loadSyncState(c.syncStateFile)
+	if err != nil {
+		slog.Warn("Ignoring unreadable sync state, doing a full sync", "error", err)
+		state = newSyncState(start, end)
+	}
+
+	if state.SyncToken != "" && !start.Before(state.Start) && !end.After(state.End) {
+		// timeMin, timeMax and orderBy can't be combined with a sync token
+		items, syncToken, err := c.listEvents(c.Svc.Events.List(c.workCalID).
+			SingleEvents(true).
+			MaxResults(2500).
+			SyncToken(state.SyncToken))
+		switch {
+		case err == nil:
+			slog.Info("Got changed events from Google", "calendar", c.String(), "changed", len(items))
+			state.apply(items)
+			state.prune(start)
+			state.SyncToken = syncToken
+			return c.saveSyncState(state, start, end), nil
+		case errors.Is(err, errSyncTokenExpired):
+			slog.Info("Sync token expired, doing a full sync", "calendar", c.String())
+		default:
+			return nil, err
+		}
+	}
+
+	listEnd := end.Add(end.Sub(start))
+	items, syncToken, err := c.listEvents(c.Svc.Events.List(c.workCalID).
+		ShowDeleted(false).
+		SingleEvents(true).
+		MaxResults(2500).
+		TimeMin(start.Format(time.RFC3339)).
+		TimeMax(listEnd.Format(time.RFC3339)))
 	if err != nil {
-		// TODO: Better error checking, why does errors.Is/As doesn't work here?
-		unwrapped := errors.Unwrap(err)
-		if _, ok := unwrapped.(*oauth2.RetrieveError); ok {
-			if strings.Contains(unwrapped.Error(), "unauthorized_client") {
-				return nil, fmt.Errorf("Invalid token.json file, got oauth unauthorized_client error: %w: %w", err, ErrInvalidToken)
-			}
+		return nil, err
+	}
+
+	state = newSyncState(start, listEnd)
+	state.apply(items)
+	state.SyncToken = syncToken
+
+	return c.saveSyncState(state, start, end), nil
+}
+
+// saveSyncState persists state and returns its events between start and end. A state
+// that can't be saved only costs a full sync on the next run. Dry runs don't persist it.
+func (c *Client) saveSyncState(state *syncState, start time.Time, end time.Time) []*Event {
+	if c.dryRun {
+		return state.eventsIn(start, end)
+	}
+	if err := state.save(c.syncStateFile); err != nil {
+		slog.Warn("Couldn't save sync state, next run will do a full sync", "error", err, "file", c.syncStateFile)
+	}
+
+	return state.eventsIn(start, end)
+}
+
+// listEvents follows all pages of call, returning the listed events and the nextSyncToken
+// sent with the last page. Results are split in pages even when below MaxResults, missing
+// one would re-create its events as duplicates.
+func (c *Client) listEvents(call *googlecalendar.EventsListCall) ([]*googlecalendar.Event, string, error) {
+	items := make([]*googlecalendar.Event, 0)
+	for {
+		gEvents, err := call.Do()
+		if err != nil {
+			return nil, "", c.listError(err)
 		}
-		if strings.Contains(unwrapped.Error(), "Not Found") {
-			// c.workCalID doesn't exist
-			slog.Error("Configured Google Calendar doesn't exist on this account", "workCalID", c.workCalID)
-			calList, err := c.Svc.CalendarList.List().Do()
-			if err != nil {
-				slog.Error("Couldn't get list of calendars")
-				os.Exit(1)
-			}
-			allExistingIDs := make([]string, len(calList.Items))
-			for _, cal := range calList.Items {
-				allExistingIDs = append(allExistingIDs, cal.Id)
-			}
-			return nil, fmt.Errorf("configured workCalID doesn't exist, got: %s, all existing IDs: %v: %w", c.workCalID, allExistingIDs, ErrCalendarNotFound)
+
+		items = append(items, gEvents.Items...)
+
+		if gEvents.NextPageToken == "" {
+			return items, gEvents.NextSyncToken, nil
 		}
+		call = call.PageToken(gEvents.NextPageToken)
+	}
+}
 
-		slog.Error("Unable to retrieve events from Google, unhandled error", "error", err)
-		os.Exit(1)
+// listError explains known errors of Events.List
+func (c *Client) listError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusGone {
+		return fmt.Errorf("%w: %w", errSyncTokenExpired, err)
 	}
 
-	events := make([]*Event, 0)
-	for _, event := range gEvents.Items {
-		events = append(events, &Event{event})
+	// TODO: Better error checking, why does errors.Is/As doesn't work here?
+	unwrapped := errors.Unwrap(err)
+	if _, ok := unwrapped.(*oauth2.RetrieveError); ok {
+		if strings.Contains(unwrapped.Error(), "unauthorized_client") {
+			return fmt.Errorf("Invalid token.json file, got oauth unauthorized_client error: %w: %w", err, ErrInvalidToken)
+		}
+	}
+	if unwrapped != nil && strings.Contains(unwrapped.Error(), "Not Found") {
+		// c.workCalID doesn't exist
+		slog.Error("Configured Google Calendar doesn't exist on this account", "workCalID", c.workCalID)
+		calList, listErr := c.Svc.CalendarList.List().Do()
+		if listErr != nil {
+			return fmt.Errorf("configured workCalID doesn't exist, got: %s, couldn't get list of calendars: %w: %w", c.workCalID, listErr, ErrCalendarNotFound)
+		}
+		allExistingIDs := make([]string, len(calList.Items))
+		for _, cal := range calList.Items {
+			allExistingIDs = append(allExistingIDs, cal.Id)
+		}
+		return fmt.Errorf("configured workCalID doesn't exist, got: %s, all existing IDs: %v: %w", c.workCalID, allExistingIDs, ErrCalendarNotFound)
 	}
 
-	return events, nil
+	return fmt.Errorf("Unable to retrieve events from Google: %w", err)
 }
 
 // PublishAllEvents unconditionally publishes new events to Google Calendar, without checking if they already exist
 func (c *Client) PublishAllEvents(events []calendar.Event) error {
 	start := time.Now()
 
+	if c.cfg.ShowAttendees {
+		events = withAttendees(events)
+	}
+
+	changes := make([]calendar.Change, 0, len(events))
 	for _, event := range events {
-		if err := c.publishEvent(event); err != nil {
-			return fmt.Errorf("Publishing the event failed: event: %s , %w", event, err)
-		}
+		changes = append(changes, localChange(calendar.ActionCreate, event, reasonNew))
+	}
+
+	// Created events are recorded as their batches succeed
+	defer c.saveEventState()
+
+	if err := c.applyBatched(changes); err != nil {
+		return fmt.Errorf("Publishing events failed: %w", err)
 	}
 
 	slog.Info("Finished adding all events to Google", "duration", time.Since(start))
@@ -160,33 +232,60 @@ func (c *Client) PublishAllEvents(events []calendar.Event) error {
 // 	return nil
 // }
 
-func (c *Client) publishEvent(event calendar.Event) error {
-	calEntry := &googlecalendar.Event{
+// newGCalPatch returns the fields of an existing Google event to replace, so that fields
+// set on Google's side (colors, reminders, etc.) and the event ID survive changes in the source.
+func newGCalPatch(event calendar.Event, namespace string) *googlecalendar.Event {
+	patch := newGCalEvent(event, namespace)
+	// Patch ignores empty values unless forced, they must be cleared when removed at the source
+	patch.ForceSendFields = []string{"Summary", "Description", "Location"}
+	// Switching between all-day and timed events must drop the other representation
+	if event.AllDay {
+		patch.Start.NullFields = []string{"DateTime", "TimeZone"}
+		patch.End.NullFields = []string{"DateTime", "TimeZone"}
+	} else {
+		patch.Start.NullFields = []string{"Date"}
+		patch.End.NullFields = []string{"Date"}
+	}
+
+	return patch
+}
+
+// newGCalEvent converts a calendar.Event to a calsync-managed Google event of namespace
+func newGCalEvent(event calendar.Event, namespace string) *googlecalendar.Event {
+	gEvent := &googlecalendar.Event{
 		Summary:     event.Title,
 		Description: event.Notes,
-		Start: &googlecalendar.EventDateTime{
-			DateTime: event.Start.Format(time.RFC3339),
-		},
-		End: &googlecalendar.EventDateTime{
-			DateTime: event.Stop.Format(time.RFC3339),
-		},
+		Location:    event.Location,
+		Start:       eventDateTime(event, event.Start),
+		End:         eventDateTime(event, event.Stop),
 		Source: &googlecalendar.EventSource{
-			Title: "calsync",
+			Title: EventSourceTitle,
 			Url:   "https://github.com/shadyabhi/calsync",
 		},
 		ExtendedProperties: &googlecalendar.EventExtendedProperties{
 			Private: map[string]string{
-				"uid": event.UID,
+				"uid":    event.UID,
+				"source": event.Calendar,
 			},
 		},
 	}
-
-	calEntry, err := c.Svc.Events.Insert(c.workCalID, calEntry).Do()
-	if err != nil {
-		return err
+	// The default namespace isn't stored, so events of older versions belong to it
+	if namespace != "" {
+		gEvent.ExtendedProperties.Private["namespace"] = namespace
 	}
 
-	slog.Info("Event created", "summary", calEntry.Summary, "start", calEntry.Start.DateTime, "end", calEntry.End.DateTime)
+	return gEvent
+}
 
-	return nil
+// eventDateTime returns a date-only EventDateTime for all-day events, date and time otherwise
+func eventDateTime(event calendar.Event, t time.Time) *googlecalendar.EventDateTime {
+	if event.AllDay {
+		return &googlecalendar.EventDateTime{
+			Date: t.Format(calendar.DateLayout),
+		}
+	}
+
+	return &googlecalendar.EventDateTime{
+		DateTime: t.Format(time.RFC3339),
+	}
 }
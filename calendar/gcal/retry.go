@@ -0,0 +1,163 @@
+package gcal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	retryBaseDelay = 1 * time.Second
+	retryMaxDelay  = 32 * time.Second
+)
+
+// retryTransport waits for the rate limiter before each request, and retries requests
+// that were rate limited or failed on Google's side with jittered exponential backoff.
+type retryTransport struct {
+	base       http.RoundTripper
+	limiter    *rate.Limiter
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+
+	// retries counts retried requests, for the summary
+	retries atomic.Int64
+	// sleep waits between attempts, replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newRetryTransport(base http.RoundTripper, requestsPerSecond float64, maxRetries int) *retryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &retryTransport{
+		base:       base,
+		limiter:    rate.NewLimiter(rate.Limit(requestsPerSecond), max(1, int(requestsPerSecond))),
+		maxRetries: maxRetries,
+		baseDelay:  retryBaseDelay,
+		maxDelay:   retryMaxDelay,
+		sleep:      sleepContext,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+
+		// Requests whose body can't be sent again are never retried
+		canReplay := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		if attempt >= t.maxRetries || !canReplay || !isRetryable(resp) {
+			return resp, nil
+		}
+
+		delay := t.backoff(attempt)
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			delay = retryAfter
+		}
+
+		slog.Warn("Retrying Google API request", "method", req.Method, "path", req.URL.Path,
+			"status", resp.StatusCode, "attempt", attempt+1, "delay", delay)
+
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		t.retries.Add(1)
+
+		if err := t.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff doubles the delay for each attempt up to maxDelay, half of it is random
+// so that clients hitting the quota together don't retry together
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.baseDelay << attempt
+	if delay <= 0 || delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// isRetryable returns true for 429, 5xx and 403 errors caused by rate limits. Other 403s
+// (e.g. no access to the calendar) would fail again. The body is kept readable.
+func isRetryable(resp *http.Response) bool {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode >= 500:
+		return true
+	case resp.StatusCode != http.StatusForbidden:
+		return false
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(body), "rateLimitExceeded") || strings.Contains(string(body), "userRateLimitExceeded")
+}
+
+// parseRetryAfter parses the Retry-After header, either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date)), true
+	}
+
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+package gcal
+
+import (
+	"calsync/calendar"
+	"calsync/state"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	googlecalendar "google.golang.org/api/calendar/v3"
+)
+
+// syncState is the local copy of a Google calendar, kept up to date with
+// incremental syncs so that only changed events are fetched on later runs.
+type syncState struct {
+	// SyncToken is the nextSyncToken of the last list, empty when a full sync is needed
+	SyncToken string
+	// Start and End bound the last full sync, events outside of them aren't in Events
+	Start time.Time
+	End   time.Time
+	// Events maps Google event IDs to events
+	Events map[string]*googlecalendar.Event
+}
+
+func newSyncState(start time.Time, end time.Time) *syncState {
+	return &syncState{
+		Start:  start,
+		End:    end,
+		Events: make(map[string]*googlecalendar.Event),
+	}
+}
+
+// loadSyncState reads the state file, a missing file is an empty state
+func loadSyncState(path string) (*syncState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newSyncState(time.Time{}, time.Time{}), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading sync state: %w", err)
+	}
+
+	s := newSyncState(time.Time{}, time.Time{})
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("decoding sync state %s: %w", path, err)
+	}
+	if s.Events == nil {
+		s.Events = make(map[string]*googlecalendar.Event)
+	}
+
+	return s, nil
+}
+
+// save writes the state with state.WriteJSON
+func (s *syncState) save(path string) error {
+	return state.WriteJSON(path, s)
+}
+
+// apply merges listed events into the state, cancelled events are removed
+func (s *syncState) apply(items []*googlecalendar.Event) {
+	for _, item := range items {
+		if item.Status == "cancelled" {
+			delete(s.Events, item.Id)
+			continue
+		}
+		s.Events[item.Id] = item
+	}
+}
+
+// prune moves Start to start, dropping events that ended before it or that start after End.
+// Changes listed with the sync token include events outside the window, and past events
+// would pile up as the window moves forward.
+func (s *syncState) prune(start time.Time) int {
+	pruned := 0
+	for id, item := range s.Events {
+		eventStart, eventEnd := parseEventDateTime(item.Start), parseEventDateTime(item.End)
+		if !eventEnd.After(start) || !eventStart.Before(s.End) {
+			delete(s.Events, id)
+			pruned++
+		}
+	}
+	s.Start = start
+
+	return pruned
+}
+
+// eventsIn returns events overlapping start and end sorted by start time,
+// the same way Events.List filters with timeMin and timeMax
+func (s *syncState) eventsIn(start time.Time, end time.Time) []*Event {
+	events := make([]*Event, 0)
+	for _, item := range s.Events {
+		eventStart, eventEnd := parseEventDateTime(item.Start), parseEventDateTime(item.End)
+		if eventEnd.After(start) && eventStart.Before(end) {
+			events = append(events, &Event{item})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		iStart, jStart := parseEventDateTime(events[i].Start), parseEventDateTime(events[j].Start)
+		if iStart.Equal(jStart) {
+			return events[i].Id < events[j].Id
+		}
+		return iStart.Before(jStart)
+	})
+
+	return events
+}
+
+// parseEventDateTime returns the time of an EventDateTime, all-day dates are local midnight
+func parseEventDateTime(dt *googlecalendar.EventDateTime) time.Time {
+	if dt == nil {
+		return time.Time{}
+	}
+	if dt.Date != "" {
+		t, _ := time.ParseInLocation(calendar.DateLayout, dt.Date, time.Local)
+		return t
+	}
+	t, _ := time.Parse(time.RFC3339, dt.DateTime)
+	return t
+}
@@ -0,0 +1,184 @@
+package ics
+
+import (
+	"bufio"
+	"calsync/calendar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ProdID identifies calsync as the producer of written calendars
+const ProdID = "-//calsync//calsync//EN"
+
+// UIDSuffix marks UIDs of events written by calsync, other events are never modified
+const UIDSuffix = "@calsync"
+
+// Properties calsync keeps on the events it writes, to tell what they were written from
+const (
+	PropSourceUID = "X-CALSYNC-SOURCE-UID"
+	PropSource    = "X-CALSYNC-SOURCE"
+	PropHash      = "X-CALSYNC-HASH"
+)
+
+// utcLayout is the RFC 5545 date-time in UTC, no VTIMEZONE is needed for it
+const utcLayout = "20060102T150405Z"
+
+// dateLayout is the RFC 5545 date of all-day events
+const dateLayout = "20060102"
+
+// maxLineOctets is where RFC 5545 folds content lines
+const maxLineOctets = 75
+
+// Property is an additional property of a VEVENT, e.g. X-CALSYNC-SOURCE
+type Property struct {
+	Name  string
+	Value string
+}
+
+// VEvent is an event as written to iCalendar data
+type VEvent struct {
+	// UID identifies the VEVENT, it replaces the source event's UID
+	UID   string
+	Event calendar.Event
+	// Stamp is the DTSTAMP, when the event was written
+	Stamp time.Time
+	// Extra properties are written after the standard ones, values are escaped as text
+	Extra []Property
+}
+
+// UID returns the UID calsync writes the source event with, stable across runs and when the
+// event is rescheduled: it's derived from the source UID and the RecurrenceID.
+func UID(event calendar.Event) string {
+	// Without source UID, a changed event can only be replaced
+	key := event.Hash()
+	if event.UID != "" {
+		key = event.UID
+		if !event.RecurrenceID.IsZero() {
+			key += "@" + event.RecurrenceID.UTC().Format(time.RFC3339)
+		}
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16]) + UIDSuffix
+}
+
+// SourceProperties returns the properties calsync keeps on events written from event
+func SourceProperties(event calendar.Event) []Property {
+	return []Property{
+		{Name: PropSourceUID, Value: event.UID},
+		{Name: PropSource, Value: event.Calendar},
+		{Name: PropHash, Value: event.Hash()},
+	}
+}
+
+// Encode writes events as a VCALENDAR. Times are written in their time zone, described by a
+// VTIMEZONE, unless it's UTC or Local.
+func Encode(w io.Writer, events []VEvent) error {
+	bw := bufio.NewWriter(w)
+	lw := &lineWriter{w: bw}
+
+	lw.line("BEGIN", "VCALENDAR")
+	lw.line("VERSION", "2.0")
+	lw.line("PRODID", ProdID)
+	lw.line("CALSCALE", "GREGORIAN")
+	writeVTimezones(lw, usedZones(events))
+	for _, event := range events {
+		writeVEvent(lw, event)
+	}
+	lw.line("END", "VCALENDAR")
+
+	if lw.err != nil {
+		return lw.err
+	}
+	return bw.Flush()
+}
+
+func writeVEvent(lw *lineWriter, v VEvent) {
+	event := v.Event
+
+	lw.line("BEGIN", "VEVENT")
+	lw.line("UID", escapeText(v.UID))
+	lw.line("DTSTAMP", v.Stamp.UTC().Format(utcLayout))
+	if event.AllDay {
+		lw.line("DTSTART;VALUE=DATE", event.Start.Format(dateLayout))
+		lw.line("DTEND;VALUE=DATE", event.Stop.Format(dateLayout))
+	} else {
+		writeDateTime(lw, "DTSTART", event.Start)
+		writeDateTime(lw, "DTEND", event.Stop)
+	}
+	lw.line("SUMMARY", escapeText(event.Title))
+	if event.Notes != "" {
+		lw.line("DESCRIPTION", escapeText(event.Notes))
+	}
+	if event.Location != "" {
+		lw.line("LOCATION", escapeText(event.Location))
+	}
+	for _, prop := range v.Extra {
+		lw.line(prop.Name, escapeText(prop.Value))
+	}
+	lw.line("END", "VEVENT")
+}
+
+// lineWriter writes folded content lines, keeping the first error
+type lineWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (lw *lineWriter) line(name string, value string) {
+	if lw.err != nil {
+		return
+	}
+	_, lw.err = lw.w.WriteString(fold(name + ":" + value))
+}
+
+// fold splits a content line into lines of at most 75 octets, continuation lines start
+// with a space. UTF-8 sequences are never split.
+func fold(line string) string {
+	var b strings.Builder
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space counts against the limit
+		limit = maxLineOctets - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+
+	return b.String()
+}
+
+// escapeText escapes a TEXT value as per RFC 5545 3.3.11
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// UnescapeText reverts escapeText, for properties the parser leaves escaped (e.g. X- ones)
+func UnescapeText(s string) string {
+	return textUnescaper.Replace(s)
+}
+
+var textUnescaper = strings.NewReplacer(
+	`\\`, `\`,
+	`\;`, ";",
+	`\,`, ",",
+	`\n`, "\n",
+	`\N`, "\n",
+)
@@ -0,0 +1,113 @@
+package ics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// fetch returns the iCalendar documents at location, which is an http(s) or webcal URL,
+// a file URL, or the path of an .ics file or of a directory of them. URLs are fetched with client.
+func fetch(ctx context.Context, client *http.Client, location string) ([][]byte, error) {
+	if !strings.Contains(location, "://") {
+		return readPath(location)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return fetchURL(ctx, client, u)
+	case "webcal", "webcals":
+		// webcal:// only tells calendar apps to subscribe, the feed is served over https
+		u.Scheme = "https"
+		return fetchURL(ctx, client, u)
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file URL %s isn't local", location)
+		}
+		return readPath(filePath(u))
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+}
+
+func fetchURL(ctx context.Context, client *http.Client, u *url.URL) ([][]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GET %s: %s", u.Redacted(), resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{body}, nil
+}
+
+// readPath reads the .ics file at path, or every .ics file of the directory at path.
+// Subdirectories and hidden files aren't read.
+func readPath(path string) ([][]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{data}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.EqualFold(filepath.Ext(name), ".ics") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, data)
+	}
+
+	return documents, nil
+}
+
+// filePath returns the local path of a file URL, file:///C:/export.ics is C:\export.ics on Windows
+func filePath(u *url.URL) string {
+	path := u.Path
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
@@ -1,38 +1,118 @@
 package ics
 
 import (
+	"bytes"
 	"calsync/calendar"
 	"calsync/config"
 	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	gocal "github.com/apognu/gocal"
+	"github.com/apognu/gocal/parser"
 )
 
+// tzMapping maps the timezone names Outlook uses to IANA ones
+// https://github.com/unicode-org/cldr/blob/main/common/supplemental/windowsZones.xml
+var tzMapping = map[string]string{
+	// US Timezones
+	"Pacific Standard Time":  "America/Los_Angeles",
+	"Mountain Standard Time": "America/Denver",
+	"Central Standard Time":  "America/Chicago",
+	"Eastern Standard Time":  "America/New_York",
+	"Alaskan Standard Time":  "America/Anchorage",
+	"Hawaiian Standard Time": "Pacific/Honolulu",
+
+	// European Timezones
+	"GMT Standard Time":              "Europe/London",
+	"Greenwich Standard Time":        "Atlantic/Reykjavik",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central European Standard Time": "Europe/Warsaw",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"FLE Standard Time":              "Europe/Kiev",
+	"Russian Standard Time":          "Europe/Moscow",
+
+	// Asia-Pacific Timezones
+	"China Standard Time":     "Asia/Shanghai",
+	"Tokyo Standard Time":     "Asia/Tokyo",
+	"Korea Standard Time":     "Asia/Seoul",
+	"Singapore Standard Time": "Asia/Singapore",
+	"India Standard Time":     "Asia/Calcutta",
+	"Arabian Standard Time":   "Asia/Riyadh",
+	"Iran Standard Time":      "Asia/Tehran",
+	"Israel Standard Time":    "Asia/Jerusalem",
+
+	// Australia/New Zealand
+	"AUS Eastern Standard Time":  "Australia/Sydney",
+	"AUS Central Standard Time":  "Australia/Darwin",
+	"W. Australia Standard Time": "Australia/Perth",
+	"New Zealand Standard Time":  "Pacific/Auckland",
+
+	// Americas
+	"Canada Central Standard Time": "America/Regina",
+	"Mexico Standard Time":         "America/Mexico_City",
+	"US Mountain Standard Time":    "America/Phoenix",
+	"Atlantic Standard Time":       "America/Halifax",
+	"Argentina Standard Time":      "America/Buenos_Aires",
+	"Brazil Standard Time":         "America/Sao_Paulo",
+	"Chile Standard Time":          "America/Santiago",
+
+	// Africa/Middle East
+	"South Africa Standard Time": "Africa/Johannesburg",
+	"Egypt Standard Time":        "Africa/Cairo",
+	"West Africa Standard Time":  "Africa/Lagos",
+	"Middle East Standard Time":  "Asia/Beirut",
+
+	// Common UTC variations
+	"UTC":                        "UTC",
+	"Coordinated Universal Time": "UTC",
+	"GMT":                        "UTC",
+}
+
+// gocal's mapper is a package global read while parsing, it's set once since sources are
+// fetched concurrently
+func init() {
+	gocal.SetTZMapper(mapTZ)
+}
+
+// mapTZ returns the location of an Outlook timezone name
+func mapTZ(name string) (*time.Location, error) {
+	if tzid, ok := tzMapping[name]; ok {
+		return time.LoadLocation(tzid)
+	}
+	return nil, fmt.Errorf("timezone %q not found in mapping", name)
+}
+
 type Calendar struct {
-	ctx context.Context
-	cfg config.ICal
-	url string
+	ctx    context.Context
+	cfg    config.ICal
+	url    string
+	client *http.Client
 }
 
 func New(ctx context.Context, cfg config.ICal) (*Calendar, error) {
 	return &Calendar{
-		ctx: ctx,
-		cfg: cfg,
-		url: cfg.URL,
+		ctx:    ctx,
+		cfg:    cfg,
+		url:    cfg.Location(),
+		client: http.DefaultClient,
 	}, nil
 }
 
 func (c *Calendar) String() string {
+	if c.cfg.Name != "" {
+		return fmt.Sprintf("ICS Calendar: %s", c.cfg.Name)
+	}
 	return fmt.Sprintf("ICS Calendar: %s", c.cfg.URL)
 }
 
-func (c *Calendar) GetEvents(start time.Time, end time.Time) ([]calendar.Event, error) {
-	events, err := getEvents(c.url, start, end)
+func (c *Calendar) GetEvents(ctx context.Context, start time.Time, end time.Time) ([]calendar.Event, error) {
+	events, err := getEvents(ctx, c.client, c.String(), c.url, start, end)
 	if err != nil {
 		return nil, err
 	}
@@ -53,79 +133,39 @@ func (c *Calendar) SyncToDest([]calendar.Event) error {
 	return fmt.Errorf("SyncToDest not implemented for ICS calendar")
 }
 
-func getEvents(url string, start time.Time, end time.Time) ([]calendar.Event, error) {
-	resp, err := http.Get(url)
+func (c *Calendar) PlanSync([]calendar.Event) (calendar.Plan, error) {
+	return calendar.Plan{}, fmt.Errorf("PlanSync not implemented for ICS calendar")
+}
+
+func (c *Calendar) ApplyPlan(calendar.Plan) error {
+	return fmt.Errorf("ApplyPlan not implemented for ICS calendar")
+}
+
+func (c *Calendar) PlanDeleteAll(_ int) (calendar.Plan, error) {
+	return calendar.Plan{}, fmt.Errorf("PlanDeleteAll not implemented for ICS calendar")
+}
+
+func getEvents(ctx context.Context, client *http.Client, source string, location string, start time.Time, end time.Time) ([]calendar.Event, error) {
+	documents, err := fetch(ctx, client, location)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch calendar: %w", err)
 	}
-	defer resp.Body.Close()
-
-	// https://github.com/unicode-org/cldr/blob/main/common/supplemental/windowsZones.xml
-	var tzMapping = map[string]string{
-		// US Timezones
-		"Pacific Standard Time":  "America/Los_Angeles",
-		"Mountain Standard Time": "America/Denver",
-		"Central Standard Time":  "America/Chicago",
-		"Eastern Standard Time":  "America/New_York",
-		"Alaskan Standard Time":  "America/Anchorage",
-		"Hawaiian Standard Time": "Pacific/Honolulu",
-
-		// European Timezones
-		"GMT Standard Time":              "Europe/London",
-		"Greenwich Standard Time":        "Atlantic/Reykjavik",
-		"W. Europe Standard Time":        "Europe/Berlin",
-		"Central Europe Standard Time":   "Europe/Budapest",
-		"Romance Standard Time":          "Europe/Paris",
-		"Central European Standard Time": "Europe/Warsaw",
-		"E. Europe Standard Time":        "Europe/Chisinau",
-		"FLE Standard Time":              "Europe/Kiev",
-		"Russian Standard Time":          "Europe/Moscow",
-
-		// Asia-Pacific Timezones
-		"China Standard Time":     "Asia/Shanghai",
-		"Tokyo Standard Time":     "Asia/Tokyo",
-		"Korea Standard Time":     "Asia/Seoul",
-		"Singapore Standard Time": "Asia/Singapore",
-		"India Standard Time":     "Asia/Calcutta",
-		"Arabian Standard Time":   "Asia/Riyadh",
-		"Iran Standard Time":      "Asia/Tehran",
-		"Israel Standard Time":    "Asia/Jerusalem",
-
-		// Australia/New Zealand
-		"AUS Eastern Standard Time":  "Australia/Sydney",
-		"AUS Central Standard Time":  "Australia/Darwin",
-		"W. Australia Standard Time": "Australia/Perth",
-		"New Zealand Standard Time":  "Pacific/Auckland",
-
-		// Americas
-		"Canada Central Standard Time": "America/Regina",
-		"Mexico Standard Time":         "America/Mexico_City",
-		"US Mountain Standard Time":    "America/Phoenix",
-		"Atlantic Standard Time":       "America/Halifax",
-		"Argentina Standard Time":      "America/Buenos_Aires",
-		"Brazil Standard Time":         "America/Sao_Paulo",
-		"Chile Standard Time":          "America/Santiago",
-
-		// Africa/Middle East
-		"South Africa Standard Time": "Africa/Johannesburg",
-		"Egypt Standard Time":        "Africa/Cairo",
-		"West Africa Standard Time":  "Africa/Lagos",
-		"Middle East Standard Time":  "Asia/Beirut",
-
-		// Common UTC variations
-		"UTC":                        "UTC",
-		"Coordinated Universal Time": "UTC",
-		"GMT":                        "UTC",
-	}
-
-	gocal.SetTZMapper(func(s string) (*time.Location, error) {
-		if tzid, ok := tzMapping[s]; ok {
-			return time.LoadLocation(tzid)
+
+	events := make([]calendar.Event, 0)
+	for _, document := range documents {
+		parsed, err := parseEvents(source, document, start, end)
+		if err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("")
-	})
+		events = append(events, parsed...)
+	}
+
+	return events, nil
+}
 
-	c := gocal.NewParser(resp.Body)
+// parseEvents parses the events of one iCalendar document between start and end
+func parseEvents(source string, document []byte, start time.Time, end time.Time) ([]calendar.Event, error) {
+	c := gocal.NewParser(bytes.NewReader(document))
 	c.Start, c.End = &start, &end
 	if err := c.Parse(); err != nil {
 		return nil, fmt.Errorf("failed to parse calendar: %w", err)
@@ -135,42 +175,129 @@ func getEvents(url string, start time.Time, end time.Time) ([]calendar.Event, er
 
 	for _, sourceEvent := range c.Events {
 		slog.Debug("Processing ICS event",
+			"source", source,
 			"uid", sourceEvent.Uid,
 			"summary", sourceEvent.Summary,
 			"start", sourceEvent.Start,
 			"end", sourceEvent.End,
 			"timezone", sourceEvent.RawStart.Params["TZID"])
 
-		// Outlook's timezones don't follow the standard
+		event := NewEvent(sourceEvent)
+
+		// All-day events (DTSTART;VALUE=DATE) have no timezone to check
+		if event.AllDay {
+			events = append(events, event)
+			continue
+		}
+
 		gotTZ := sourceEvent.RawStart.Params["TZID"]
-		if isUnknownTZ(tzMapping, gotTZ) {
-			// Timezone not found in mapping is a hard error, we abort!
-			slog.Error("Timezone not found in mapping, using UTC", "timezone", gotTZ, "event", sourceEvent)
-			os.Exit(1)
+		if isUnknownTZ(gotTZ) {
+			// gocal would have used UTC, the whole source fails instead of syncing wrong times
+			return nil, fmt.Errorf("timezone %q of event %q is unknown", gotTZ, sourceEvent.Uid)
 		}
 
-		event := calendar.Event{}
-		event.Title = sourceEvent.Summary
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// NewEvent converts a VEVENT parsed by gocal, which must have a start and an end
+func NewEvent(sourceEvent gocal.Event) calendar.Event {
+	event := calendar.Event{}
+	event.Title = sourceEvent.Summary
+	event.Location = sourceEvent.Location
+	event.UID = sourceEvent.Uid
+	event.Organizer, event.Attendees = getAttendees(sourceEvent)
 
+	if isAllDay(sourceEvent) {
+		event.AllDay = true
+		event.Start, event.Stop = allDayRange(*sourceEvent.Start, *sourceEvent.End)
+	} else {
 		event.Start = *sourceEvent.Start
 		event.Stop = *sourceEvent.End
+	}
+	event.RecurrenceID = recurrenceID(sourceEvent, event.Start)
 
-		event.UID = sourceEvent.Uid
+	return event
+}
 
-		events = append(events, event)
+// recurrenceID returns the start an instance of a recurring event was scheduled at: its
+// RECURRENCE-ID when it was moved, start when it was expanded from the RRULE
+func recurrenceID(e gocal.Event, start time.Time) time.Time {
+	if e.RecurrenceID != "" {
+		// gocal reads RECURRENCE-ID with the parameters of DTSTART too
+		rid, err := parser.ParseTime(e.RecurrenceID, e.RawStart.Params, parser.TimeStart, false, time.Local)
+		if err == nil {
+			return *rid
+		}
 	}
+	if e.IsRecurring {
+		return start
+	}
+	return time.Time{}
+}
 
-	return events, nil
+// isUnknownTZ checks if gocal couldn't resolve the timezone, neither with the mapping nor as
+// an IANA name. Times without TZID are UTC or floating, they need none.
+func isUnknownTZ(gotTZ string) bool {
+	if gotTZ == "" {
+		return false
+	}
+	if _, err := mapTZ(gotTZ); err == nil {
+		return false
+	}
+	_, err := parser.LoadTimezone(gotTZ)
+	return err != nil
 }
 
-// isUnknownTZ checks if the timezone is not found in the mapping
-func isUnknownTZ(tzMapping map[string]string, gotTZ string) bool {
-	var found bool
-	for k := range tzMapping {
-		if gotTZ == k {
-			found = true
-			break
+// isAllDay checks if the event is date-only, as per RFC 5545 3.3.4
+func isAllDay(e gocal.Event) bool {
+	return e.RawStart.Params["VALUE"] == "DATE" || len(e.RawStart.Value) == len("20060102")
+}
+
+// allDayRange converts gocal's all-day start/end to local midnights with an exclusive stop.
+// Depending on DTEND/DURATION, gocal gives an end just before midnight or at midnight,
+// so the stop is rounded up to the next day boundary.
+func allDayRange(start, end time.Time) (time.Time, time.Time) {
+	startDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local)
+
+	stopDay := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.Local)
+	if end.Hour() != 0 || end.Minute() != 0 || end.Second() != 0 || end.Nanosecond() != 0 {
+		stopDay = stopDay.AddDate(0, 0, 1)
+	}
+	if !stopDay.After(startDay) {
+		stopDay = startDay.AddDate(0, 0, 1)
+	}
+
+	return startDay, stopDay
+}
+
+// getAttendees converts ORGANIZER and ATTENDEE properties, their value is usually a mailto: URI
+func getAttendees(e gocal.Event) (*calendar.Attendee, []calendar.Attendee) {
+	var organizer *calendar.Attendee
+	if e.Organizer != nil {
+		organizer = &calendar.Attendee{
+			Name:  e.Organizer.Cn,
+			Email: trimMailto(e.Organizer.Value),
 		}
 	}
-	return !found
+
+	attendees := make([]calendar.Attendee, 0, len(e.Attendees))
+	for _, a := range e.Attendees {
+		attendees = append(attendees, calendar.Attendee{
+			Name:   a.Cn,
+			Email:  trimMailto(a.Value),
+			Status: strings.ToUpper(a.Status),
+		})
+	}
+
+	return organizer, attendees
+}
+
+func trimMailto(value string) string {
+	if len(value) >= len("mailto:") && strings.EqualFold(value[:len("mailto:")], "mailto:") {
+		return value[len("mailto:"):]
+	}
+	return value
 }
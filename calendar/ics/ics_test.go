@@ -4,17 +4,33 @@ import (
 	"calsync/calendar"
 	"calsync/config"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	gocal "github.com/apognu/gocal"
 )
 
 func serveICSFile(filename string) *httptest.Server {
-	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return httptest.NewServer(icsFileHandler(filename))
+}
+
+// serveICSFileTLS serves filename over https until the test ends, server.Client() trusts it
+func serveICSFileTLS(t *testing.T, filename string) *httptest.Server {
+	server := httptest.NewTLSServer(icsFileHandler(filename))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func icsFileHandler(filename string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		file, err := os.Open(filename)
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
@@ -26,7 +42,7 @@ func serveICSFile(filename string) *httptest.Server {
 		if err != nil {
 			http.Error(w, "Failed to read ICS file", http.StatusInternalServerError)
 		}
-	}))
+	})
 }
 
 func TestGetEvents(t *testing.T) {
@@ -53,6 +69,9 @@ func TestGetEvents(t *testing.T) {
 				if event.Title != "Data::ICal release party,other things with slash\\es" {
 					t.Errorf("Expected title 'Data::ICal release party,other things with slash\\es', got '%s'", event.Title)
 				}
+				if event.Location != "The Restaurant at the End of the Universe" {
+					t.Errorf("Expected location 'The Restaurant at the End of the Universe', got '%s'", event.Location)
+				}
 				if event.UID != "test-event-1@example.com" {
 					t.Errorf("Expected UID 'test-event-1@example.com', got '%s'", event.UID)
 				}
@@ -97,6 +116,59 @@ func TestGetEvents(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:          "all-day events",
+			icsFile:       "testdata/allday.ics",
+			startDate:     time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+			endDate:       time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC),
+			expectedCount: 2,
+			validateEvents: func(t *testing.T, events []calendar.Event) {
+				expected := []struct {
+					title       string
+					start, stop time.Time
+				}{
+					{"PTO", time.Date(2024, 8, 15, 0, 0, 0, 0, time.Local), time.Date(2024, 8, 17, 0, 0, 0, 0, time.Local)},
+					{"Holiday", time.Date(2024, 8, 20, 0, 0, 0, 0, time.Local), time.Date(2024, 8, 21, 0, 0, 0, 0, time.Local)},
+				}
+				for i, event := range events {
+					if !event.AllDay {
+						t.Errorf("Event %d: expected all-day event", i)
+					}
+					if event.Title != expected[i].title {
+						t.Errorf("Event %d: expected title '%s', got '%s'", i, expected[i].title, event.Title)
+					}
+					if !event.Start.Equal(expected[i].start) || !event.Stop.Equal(expected[i].stop) {
+						t.Errorf("Event %d: expected %v - %v, got %v - %v", i, expected[i].start, expected[i].stop, event.Start, event.Stop)
+					}
+				}
+			},
+		},
+		{
+			name:          "organizer and attendees",
+			icsFile:       "testdata/attendees.ics",
+			startDate:     time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+			endDate:       time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC),
+			expectedCount: 1,
+			validateEvents: func(t *testing.T, events []calendar.Event) {
+				event := events[0]
+				wantOrganizer := calendar.Attendee{Name: "Jane Doe", Email: "jane@example.com"}
+				if event.Organizer == nil || *event.Organizer != wantOrganizer {
+					t.Errorf("Expected organizer %v, got %v", wantOrganizer, event.Organizer)
+				}
+				wantAttendees := []calendar.Attendee{
+					{Name: "John Doe", Email: "john@example.com", Status: "ACCEPTED"},
+					{Email: "me@example.com", Status: "NEEDS-ACTION"},
+				}
+				if len(event.Attendees) != len(wantAttendees) {
+					t.Fatalf("Expected %d attendees, got %d", len(wantAttendees), len(event.Attendees))
+				}
+				for i, attendee := range event.Attendees {
+					if attendee != wantAttendees[i] {
+						t.Errorf("Attendee %d: expected %v, got %v", i, wantAttendees[i], attendee)
+					}
+				}
+			},
+		},
 		{
 			name:          "events outside date range",
 			icsFile:       "testdata/test.ics",
@@ -116,7 +188,46 @@ func TestGetEvents(t *testing.T) {
 			icsFile:       "testdata/nonexistent.ics",
 			startDate:     time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
 			endDate:       time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC),
-			expectedCount: 0,
+			expectedError: true,
+			errorContains: "404 Not Found",
+		},
+		{
+			name:          "IANA timezone",
+			icsFile:       "testdata/ianatz.ics",
+			startDate:     time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+			endDate:       time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC),
+			expectedCount: 1,
+			validateEvents: func(t *testing.T, events []calendar.Event) {
+				expectedStart := time.Date(2024, 8, 15, 17, 0, 0, 0, time.UTC)
+				if !events[0].Start.Equal(expectedStart) {
+					t.Errorf("Expected start time %v, got %v", expectedStart, events[0].Start)
+				}
+			},
+		},
+		{
+			name:          "UTC without timezone",
+			icsFile:       "testdata/utc.ics",
+			startDate:     time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+			endDate:       time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC),
+			expectedCount: 1,
+			validateEvents: func(t *testing.T, events []calendar.Event) {
+				expectedStart := time.Date(2024, 8, 15, 17, 0, 0, 0, time.UTC)
+				if !events[0].Start.Equal(expectedStart) {
+					t.Errorf("Expected start time %v, got %v", expectedStart, events[0].Start)
+				}
+				expectedEnd := time.Date(2024, 8, 15, 18, 0, 0, 0, time.UTC)
+				if !events[0].Stop.Equal(expectedEnd) {
+					t.Errorf("Expected end time %v, got %v", expectedEnd, events[0].Stop)
+				}
+			},
+		},
+		{
+			name:          "unknown timezone",
+			icsFile:       "testdata/unknowntz.ics",
+			startDate:     time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+			endDate:       time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC),
+			expectedError: true,
+			errorContains: `timezone "Mars Standard Time" of event "event1@test.com" is unknown`,
 		},
 	}
 
@@ -132,7 +243,7 @@ func TestGetEvents(t *testing.T) {
 				t.Fatalf("Failed to create calendar: %v", err)
 			}
 
-			events, err := cal.GetEvents(tt.startDate, tt.endDate)
+			events, err := cal.GetEvents(ctx, tt.startDate, tt.endDate)
 			if tt.expectedError {
 				if err == nil {
 					t.Error("Expected error, got nil")
@@ -164,10 +275,15 @@ func TestGetEventsErrors(t *testing.T) {
 		expectedError string
 	}{
 		{
-			name:          "invalid URL",
+			name:          "missing file",
 			url:           "invalid-url",
 			expectedError: "failed to fetch calendar",
 		},
+		{
+			name:          "invalid URL",
+			url:           "http://[::1",
+			expectedError: "missing ']' in host",
+		},
 	}
 
 	for _, tt := range tests {
@@ -182,7 +298,7 @@ func TestGetEventsErrors(t *testing.T) {
 			start := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
 			end := time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC)
 
-			_, err = cal.GetEvents(start, end)
+			_, err = cal.GetEvents(ctx, start, end)
 			if err == nil {
 				t.Error("Expected error, got nil")
 			} else if !strings.Contains(err.Error(), tt.expectedError) {
@@ -191,3 +307,357 @@ func TestGetEventsErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestGetEventsLocations(t *testing.T) {
+	multiple, err := filepath.Abs("testdata/multiple.ics")
+	if err != nil {
+		t.Fatalf("Failed to get path of testdata: %v", err)
+	}
+	// file:///C:/... on Windows
+	urlPath := filepath.ToSlash(multiple)
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+
+	// A directory of exports, only .ics files directly in it are read
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"multiple.ics":       "testdata/multiple.ics",
+		"ALLDAY.ICS":         "testdata/allday.ics",
+		"notes.txt":          "testdata/allday.ics",
+		".hidden.ics":        "testdata/allday.ics",
+		"archive/2023.ics":   "testdata/multiple.ics",
+		"multiple.ics.tmp":   "testdata/multiple.ics",
+		"attendees.ics.orig": "testdata/attendees.ics",
+	} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", src, err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	tls := serveICSFileTLS(t, "testdata/multiple.ics")
+
+	tests := []struct {
+		name          string
+		url           string
+		expectedCount int
+		errorContains string
+	}{
+		{name: "path", url: multiple, expectedCount: 3},
+		{name: "file URL", url: "file://" + urlPath, expectedCount: 3},
+		{name: "file URL of localhost", url: "file://localhost" + urlPath, expectedCount: 3},
+		{name: "directory", url: dir, expectedCount: 5},
+		{name: "webcal is fetched over https", url: strings.Replace(tls.URL, "https://", "webcal://", 1), expectedCount: 3},
+		{name: "missing file", url: filepath.Join(dir, "missing.ics"), errorContains: "failed to fetch calendar"},
+		{name: "file URL of another host", url: "file://fileserver/export.ics", errorContains: "isn't local"},
+		{name: "unsupported scheme", url: "ftp://example.com/calendar.ics", errorContains: `unsupported URL scheme "ftp"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cal, err := New(ctx, config.ICal{URL: tt.url})
+			if err != nil {
+				t.Fatalf("Failed to create calendar: %v", err)
+			}
+			cal.client = tls.Client()
+
+			start := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+			end := time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC)
+			events, err := cal.GetEvents(ctx, start, end)
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', got '%v'", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(events) != tt.expectedCount {
+				t.Errorf("Expected %d events, got %d", tt.expectedCount, len(events))
+			}
+		})
+	}
+}
+
+func TestGetEventsServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "BEGIN:VCALENDAR", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cal, err := New(context.Background(), config.ICal{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create calendar: %v", err)
+	}
+
+	start := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC)
+
+	events, err := cal.GetEvents(context.Background(), start, end)
+	if err == nil || !strings.Contains(err.Error(), "500 Internal Server Error") {
+		t.Errorf("Expected error containing '500 Internal Server Error', got '%v'", err)
+	}
+	if events != nil {
+		t.Errorf("Expected no events, got %v", events)
+	}
+}
+
+func TestGetEventsTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	cal, err := New(context.Background(), config.ICal{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create calendar: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC)
+
+	_, err = cal.GetEvents(ctx, start, end)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got '%v'", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	start := time.Date(2026, 5, 4, 9, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	notes := strings.Repeat("Agenda: café, croissants; ", 8) + "\nthe end"
+	events := []VEvent{
+		{
+			UID:   "standup@calsync",
+			Event: calendar.Event{Title: "Standup, team; all", Notes: notes, Location: "Room 1", Start: start, Stop: start.Add(15 * time.Minute)},
+			Stamp: start,
+			Extra: []Property{{Name: "X-CALSYNC-SOURCE", Value: "ICS Calendar: on-call, EU"}},
+		},
+		{
+			UID:   "offsite@calsync",
+			Event: calendar.Event{Title: "Offsite", Start: time.Date(2026, 5, 6, 0, 0, 0, 0, time.Local), Stop: time.Date(2026, 5, 8, 0, 0, 0, 0, time.Local), AllDay: true},
+			Stamp: start,
+		},
+	}
+
+	var b strings.Builder
+	if err := Encode(&b, events); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	data := b.String()
+
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Line longer than 75 octets: %q", line)
+		}
+	}
+	for _, want := range []string{"DTSTART:20260504T073000Z\r\n", "DTSTART;VALUE=DATE:20260506\r\n", "DTEND;VALUE=DATE:20260508\r\n", "SUMMARY:Standup\\, team\\; all\r\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("Expected %q in:\n%s", want, data)
+		}
+	}
+
+	parser := gocal.NewParser(strings.NewReader(data))
+	parser.SkipBounds = true
+	if err := parser.Parse(); err != nil {
+		t.Fatalf("Encoded calendar doesn't parse: %v", err)
+	}
+	if len(parser.Events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(parser.Events))
+	}
+
+	got := parser.Events[0]
+	if got.Uid != "standup@calsync" || got.Summary != "Standup, team; all" || got.Location != "Room 1" {
+		t.Errorf("Unexpected event: uid=%q summary=%q location=%q", got.Uid, got.Summary, got.Location)
+	}
+	// gocal unescapes commas and semicolons, but not newlines
+	if description := strings.ReplaceAll(got.Description, `\n`, "\n"); description != notes {
+		t.Errorf("Description didn't survive folding:\ngot  %q\nwant %q", description, notes)
+	}
+	if !got.Start.Equal(start) {
+		t.Errorf("Expected start %s, got %s", start, got.Start)
+	}
+	if source := UnescapeText(got.CustomAttributes["X-CALSYNC-SOURCE"]); source != "ICS Calendar: on-call, EU" {
+		t.Errorf("Expected X-CALSYNC-SOURCE to round-trip, got %q", source)
+	}
+}
+
+func TestEncodeTimezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("No time zone database: %v", err)
+	}
+	winter := time.Date(2026, 1, 12, 10, 0, 0, 0, berlin)
+	autumn := time.Date(2026, 11, 9, 10, 0, 0, 0, berlin)
+	events := []VEvent{
+		{UID: "winter@calsync", Event: calendar.Event{Title: "Winter", Start: winter, Stop: winter.Add(time.Hour)}, Stamp: winter},
+		{UID: "autumn@calsync", Event: calendar.Event{Title: "Autumn", Start: autumn, Stop: autumn.Add(time.Hour)}, Stamp: winter},
+	}
+
+	var b strings.Builder
+	if err := Encode(&b, events); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	data := b.String()
+
+	// The observance in effect at the first event, then each change until the last one
+	want := strings.Join([]string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Berlin",
+		"BEGIN:STANDARD", "DTSTART:20251026T030000", "TZOFFSETFROM:+0200", "TZOFFSETTO:+0100", "TZNAME:CET", "END:STANDARD",
+		"BEGIN:DAYLIGHT", "DTSTART:20260329T020000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0200", "TZNAME:CEST", "END:DAYLIGHT",
+		"BEGIN:STANDARD", "DTSTART:20261025T030000", "TZOFFSETFROM:+0200", "TZOFFSETTO:+0100", "TZNAME:CET", "END:STANDARD",
+		"END:VTIMEZONE",
+	}, "\r\n")
+	if !strings.Contains(data, want) {
+		t.Errorf("Expected VTIMEZONE\n%s\nin:\n%s", want, data)
+	}
+	for _, want := range []string{"DTSTART;TZID=Europe/Berlin:20260112T100000\r\n", "DTEND;TZID=Europe/Berlin:20261109T110000\r\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("Expected %q in:\n%s", want, data)
+		}
+	}
+
+	parser := gocal.NewParser(strings.NewReader(data))
+	parser.SkipBounds = true
+	if err := parser.Parse(); err != nil {
+		t.Fatalf("Encoded calendar doesn't parse: %v", err)
+	}
+	for i, got := range parser.Events {
+		if !got.Start.Equal(events[i].Event.Start) || got.Start.Location().String() != "Europe/Berlin" {
+			t.Errorf("Expected start %s, got %s", events[i].Event.Start, got.Start)
+		}
+	}
+}
+
+func TestUID(t *testing.T) {
+	start := time.Date(2024, 8, 12, 9, 0, 0, 0, time.UTC)
+	event := calendar.Event{Title: "Dentist", Start: start, Stop: start.Add(time.Hour), UID: "dentist@test.com"}
+
+	rescheduled := event
+	rescheduled.Start, rescheduled.Stop = start.Add(48*time.Hour), start.Add(49*time.Hour)
+	if UID(event) != UID(rescheduled) {
+		t.Errorf("Rescheduling changed the UID from %s to %s", UID(event), UID(rescheduled))
+	}
+
+	event.UID, rescheduled.UID = "", ""
+	if UID(event) == UID(rescheduled) {
+		t.Errorf("Events without source UID must be identified by their content")
+	}
+}
+
+func TestUIDRecurring(t *testing.T) {
+	server := serveICSFile("testdata/recurring.ics")
+	defer server.Close()
+
+	cal, err := New(context.Background(), config.ICal{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create calendar: %v", err)
+	}
+	start := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 8, 31, 23, 59, 59, 0, time.UTC)
+	events, err := cal.GetEvents(context.Background(), start, end)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 instances, got %d", len(events))
+	}
+
+	uids := make(map[string]bool)
+	for _, event := range events {
+		uids[UID(event)] = true
+	}
+	if len(uids) != 3 {
+		t.Errorf("Instances of a recurring event must have their own UID, got %v", uids)
+	}
+
+	losAngeles, _ := time.LoadLocation("America/Los_Angeles")
+	scheduled := time.Date(2024, 8, 13, 9, 0, 0, 0, losAngeles)
+	moved := 0
+	for _, event := range events {
+		if event.Title != "Standup (moved)" {
+			if !event.RecurrenceID.Equal(event.Start) {
+				t.Errorf("Expected RecurrenceID %s, got %s", event.Start, event.RecurrenceID)
+			}
+			continue
+		}
+		moved++
+		if !event.RecurrenceID.Equal(scheduled) {
+			t.Errorf("Expected RecurrenceID %s of the moved instance, got %s", scheduled, event.RecurrenceID)
+		}
+		// The same instance, before it was moved
+		unmoved := event
+		unmoved.Title, unmoved.Start, unmoved.Stop = "Standup", scheduled, scheduled.Add(15*time.Minute)
+		if UID(event) != UID(unmoved) {
+			t.Errorf("Moving an instance changed its UID from %s to %s", UID(unmoved), UID(event))
+		}
+	}
+	if moved != 1 {
+		t.Errorf("Expected the moved instance, got %d", moved)
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	tests := map[int]string{
+		0:                 "+0000",
+		2 * 60 * 60:       "+0200",
+		-(3*60*60 + 1800): "-0330",
+		5*60*60 + 45*60:   "+0545",
+		-(17*60 + 30):     "-001730",
+	}
+	for seconds, want := range tests {
+		if got := formatOffset(seconds); got != want {
+			t.Errorf("formatOffset(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
+
+// Sources are fetched concurrently, run with -race
+func TestGetEventsConcurrent(t *testing.T) {
+	start := time.Date(2005, 8, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2005, 8, 31, 23, 59, 59, 0, time.UTC)
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	want := time.Date(2005, 8, 2, 17, 0, 0, 0, london)
+	path, err := filepath.Abs(filepath.Join("testdata", "test.ics"))
+	if err != nil {
+		t.Fatalf("Failed to get path: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		cal, err := New(context.Background(), config.ICal{URL: path})
+		if err != nil {
+			t.Fatalf("Failed to create calendar: %v", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, err := cal.GetEvents(context.Background(), start, end)
+			if err != nil {
+				t.Errorf("Failed to get events: %v", err)
+				return
+			}
+			if len(events) != 1 || !events[0].Start.Equal(want) {
+				t.Errorf("Expected one event at %v, got %v", want, events)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,159 @@
+package ics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// localLayout is the RFC 5545 date-time in the time zone of its TZID parameter
+const localLayout = "20060102T150405"
+
+// tzidOf returns the TZID times in loc are written with, empty when they're written in UTC.
+// Only IANA zones are written with a TZID, other tools can't tell what e.g. Local or CEST is.
+func tzidOf(loc *time.Location) string {
+	name := loc.String()
+	if name == "UTC" || name == "Local" {
+		return ""
+	}
+
+	knownZonesMu.Lock()
+	defer knownZonesMu.Unlock()
+	known, ok := knownZones[name]
+	if !ok {
+		_, err := time.LoadLocation(name)
+		known = err == nil
+		knownZones[name] = known
+	}
+	if !known {
+		return ""
+	}
+	return name
+}
+
+// knownZones caches whether a zone name is an IANA zone, loading it reads the zone database
+var (
+	knownZonesMu sync.Mutex
+	knownZones   = make(map[string]bool)
+)
+
+// zoneRange is a time zone and the range of the event times in it
+type zoneRange struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+// usedZones returns the time zones of the timed events by TZID, with the range each is used in
+func usedZones(events []VEvent) map[string]*zoneRange {
+	zones := make(map[string]*zoneRange)
+	for _, v := range events {
+		if v.Event.AllDay {
+			continue
+		}
+		for _, t := range []time.Time{v.Event.Start, v.Event.Stop} {
+			tzid := tzidOf(t.Location())
+			if tzid == "" {
+				continue
+			}
+			z, ok := zones[tzid]
+			if !ok {
+				zones[tzid] = &zoneRange{loc: t.Location(), from: t, to: t}
+				continue
+			}
+			if t.Before(z.from) {
+				z.from = t
+			}
+			if t.After(z.to) {
+				z.to = t
+			}
+		}
+	}
+	return zones
+}
+
+// writeVTimezones writes a VTIMEZONE for every zone, sorted by TZID
+func writeVTimezones(lw *lineWriter, zones map[string]*zoneRange) {
+	tzids := make([]string, 0, len(zones))
+	for tzid := range zones {
+		tzids = append(tzids, tzid)
+	}
+	sort.Strings(tzids)
+
+	for _, tzid := range tzids {
+		writeVTimezone(lw, tzid, zones[tzid])
+	}
+}
+
+// writeVTimezone describes the zone with one observance per offset change between from and to,
+// starting with the one in effect at from. Go only knows the transitions, not the rules they
+// follow, so the observances have no RRULE.
+func writeVTimezone(lw *lineWriter, tzid string, z *zoneRange) {
+	lw.line("BEGIN", "VTIMEZONE")
+	lw.line("TZID", tzid)
+
+	t := z.from.In(z.loc)
+	for {
+		start, end := t.ZoneBounds()
+		name, offset := t.Zone()
+
+		offsetFrom := offset
+		onset := "19700101T000000"
+		if !start.IsZero() {
+			_, offsetFrom = start.Add(-time.Second).Zone()
+			// The onset is in the local time before the change
+			onset = start.UTC().Add(time.Duration(offsetFrom) * time.Second).Format(localLayout)
+		}
+
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		lw.line("BEGIN", kind)
+		lw.line("DTSTART", onset)
+		lw.line("TZOFFSETFROM", formatOffset(offsetFrom))
+		lw.line("TZOFFSETTO", formatOffset(offset))
+		lw.line("TZNAME", escapeText(name))
+		lw.line("END", kind)
+
+		if end.IsZero() || end.After(z.to) {
+			break
+		}
+		t = end
+	}
+
+	lw.line("END", "VTIMEZONE")
+}
+
+// formatOffset formats seconds east of UTC as an RFC 5545 UTC offset, e.g. +0100
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	h, m, s := seconds/3600, seconds%3600/60, seconds%60
+	if s != 0 {
+		return fmt.Sprintf("%c%02d%02d%02d", sign, h, m, s)
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, h, m)
+}
+
+// writeDateTime writes a DTSTART or DTEND, in its time zone when it has a TZID
+func writeDateTime(lw *lineWriter, name string, t time.Time) {
+	if tzid := tzidOf(t.Location()); tzid != "" {
+		lw.line(name+";TZID="+escapeParam(tzid), t.Format(localLayout))
+		return
+	}
+	lw.line(name, t.UTC().Format(utcLayout))
+}
+
+// escapeParam quotes parameter values with characters that end them
+func escapeParam(value string) string {
+	for _, r := range value {
+		if r == ':' || r == ';' || r == ',' {
+			return `"` + value + `"`
+		}
+	}
+	return value
+}
@@ -0,0 +1,354 @@
+// Package icsfile writes the synced events to a local iCalendar file (RFC 5545), for other
+// tools to read, e.g. khal, mutt or a static site.
+package icsfile
+
+import (
+	"bytes"
+	"calsync/calendar"
+	"calsync/calendar/ics"
+	"calsync/config"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	gocal "github.com/apognu/gocal"
+)
+
+const (
+	reasonInSync    = "already synced"
+	reasonChanged   = "changed at source"
+	reasonStale     = "not at source anymore"
+	reasonNew       = "new at source"
+	reasonDeleteAll = "calsync managed"
+)
+
+// Calendar is an .ics file holding exactly the events synced to it. The whole file is
+// calsync's, a file written by anything else is never overwritten.
+type Calendar struct {
+	cfg  config.ICSFile
+	path string
+
+	// written are the events in the file when it was last read, keyed by UID
+	written map[string]ics.VEvent
+}
+
+func New(cfg config.ICSFile) *Calendar {
+	return &Calendar{
+		cfg:  cfg,
+		path: cfg.File(),
+	}
+}
+
+func (c *Calendar) String() string {
+	if c.cfg.Name != "" {
+		return fmt.Sprintf("ICS File: %s", c.cfg.Name)
+	}
+	return fmt.Sprintf("ICS File: %s", c.path)
+}
+
+func (c *Calendar) GetEvents(_ context.Context, _ time.Time, _ time.Time) ([]calendar.Event, error) {
+	return nil, fmt.Errorf("GetEvents not implemented for ICS file calendar")
+}
+
+func (c *Calendar) PutEvents() error {
+	return fmt.Errorf("PutEvents not implemented for ICS file calendar")
+}
+
+// SyncToDest will write all events to the file, like gcal.Client.SyncToDest
+func (c *Calendar) SyncToDest(events []calendar.Event) error {
+	plan, err := c.PlanSync(events)
+	if err != nil {
+		return err
+	}
+	return c.ApplyPlan(plan)
+}
+
+func (c *Calendar) DeleteAll(nDays int) error {
+	plan, err := c.PlanDeleteAll(nDays)
+	if err != nil {
+		return err
+	}
+	return c.ApplyPlan(plan)
+}
+
+// read loads the events of the file into written, a missing or empty file has none
+func (c *Calendar) read() error {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		c.written = make(map[string]ics.VEvent)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", c.path, err)
+	}
+
+	written, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", c.path, err)
+	}
+	c.written = written
+
+	return nil
+}
+
+// parse reads the events of a file written by calsync, keyed by UID
+func parse(data []byte) (map[string]ics.VEvent, error) {
+	written := make(map[string]ics.VEvent)
+	if len(bytes.TrimSpace(data)) == 0 {
+		return written, nil
+	}
+	if !bytes.Contains(data, []byte("PRODID:"+ics.ProdID)) {
+		return nil, fmt.Errorf("not written by calsync, refusing to overwrite it")
+	}
+
+	// Every event calsync writes is a single instance, so none is out of bounds
+	start, end := time.Time{}, time.Now().AddDate(100, 0, 0)
+	parser := gocal.NewParser(bytes.NewReader(data))
+	parser.Start, parser.End = &start, &end
+	parser.SkipBounds = true
+	if err := parser.Parse(); err != nil {
+		return nil, err
+	}
+
+	for _, e := range parser.Events {
+		if e.Start == nil || e.End == nil {
+			continue
+		}
+
+		event := ics.NewEvent(e)
+		// gocal unescapes commas and semicolons, but not newlines
+		event.Notes = ics.UnescapeText(e.Description)
+		event.UID = ics.UnescapeText(e.CustomAttributes[ics.PropSourceUID])
+		event.Calendar = ics.UnescapeText(e.CustomAttributes[ics.PropSource])
+
+		v := ics.VEvent{
+			UID:   e.Uid,
+			Event: event,
+			Extra: []ics.Property{
+				{Name: ics.PropSourceUID, Value: event.UID},
+				{Name: ics.PropSource, Value: event.Calendar},
+				// The hash of the event as written, before any round trip through the file
+				{Name: ics.PropHash, Value: ics.UnescapeText(e.CustomAttributes[ics.PropHash])},
+			},
+		}
+		if e.Stamp != nil {
+			v.Stamp = *e.Stamp
+		}
+		written[e.Uid] = v
+	}
+
+	return written, nil
+}
+
+// hashOf returns the hash of the source event v was written from
+func hashOf(v ics.VEvent) string {
+	for _, prop := range v.Extra {
+		if prop.Name == ics.PropHash {
+			return prop.Value
+		}
+	}
+	return ""
+}
+
+// PlanSync computes what SyncToDest would do, without writing the file. Events in the file
+// but not in events are deleted, whatever their time.
+func (c *Calendar) PlanSync(events []calendar.Event) (calendar.Plan, error) {
+	plan := calendar.Plan{Calendar: c.String()}
+
+	// An empty file is more likely a failed run than an empty calendar
+	if len(events) == 0 {
+		slog.Warn("No events to sync, skipping to avoid deleting calsync events", "calendar", c.String())
+		return plan, nil
+	}
+
+	if err := c.read(); err != nil {
+		return plan, err
+	}
+
+	slog.Info("Planning sync of all events", "total_local_events", len(events), "total_file_events", len(c.written))
+
+	calendar.Events(events).SortStartTime()
+	local := make(map[string]calendar.Event, len(events))
+	for _, event := range events {
+		local[ics.UID(event)] = event
+	}
+
+	for _, v := range c.sortedWritten() {
+		event, ok := local[v.UID]
+		switch {
+		case !ok:
+			plan.Changes = append(plan.Changes, writtenChange(calendar.ActionDelete, v, reasonStale))
+		case hashOf(v) == event.Hash():
+			change := writtenChange(calendar.ActionSkip, v, reasonInSync)
+			change.Event = event
+			plan.Changes = append(plan.Changes, change)
+		default:
+			change := localChange(calendar.ActionUpdate, event, reasonChanged)
+			change.ID = v.UID
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+
+	planned := make(map[string]bool)
+	for _, event := range events {
+		uid := ics.UID(event)
+		if _, ok := c.written[uid]; ok || planned[uid] {
+			continue
+		}
+		planned[uid] = true
+		plan.Changes = append(plan.Changes, localChange(calendar.ActionCreate, event, reasonNew))
+	}
+
+	return plan, nil
+}
+
+// PlanDeleteAll computes what DeleteAll would remove, without writing the file
+func (c *Calendar) PlanDeleteAll(nDays int) (calendar.Plan, error) {
+	plan := calendar.Plan{Calendar: c.String()}
+
+	if err := c.read(); err != nil {
+		return plan, err
+	}
+
+	now := time.Now()
+	start, end := now.AddDate(0, 0, -1), now.AddDate(0, 0, nDays)
+	for _, v := range c.sortedWritten() {
+		if v.Event.Start.Before(end) && v.Event.Stop.After(start) {
+			plan.Changes = append(plan.Changes, writtenChange(calendar.ActionDelete, v, reasonDeleteAll))
+		}
+	}
+
+	return plan, nil
+}
+
+// ApplyPlan writes the file with the changes in the plan made to it. Events the plan doesn't
+// change are kept as they are, DTSTAMP included. An unchanged file isn't written at all.
+func (c *Calendar) ApplyPlan(plan calendar.Plan) error {
+	if c.written == nil {
+		if err := c.read(); err != nil {
+			return err
+		}
+	}
+
+	events := make(map[string]ics.VEvent, len(c.written))
+	for uid, v := range c.written {
+		events[uid] = v
+	}
+
+	now := time.Now()
+	changed := false
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case calendar.ActionSkip, calendar.ActionIgnore, calendar.ActionPreserve:
+			slog.Info("Skipping", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+		case calendar.ActionCreate:
+			slog.Info("Event created", "summary", change.Title, "start", change.Start, "end", change.End)
+			events[ics.UID(change.Event)] = newVEvent(change.Event, now)
+			changed = true
+		case calendar.ActionUpdate:
+			slog.Info("Changed, updating", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+			events[change.ID] = newVEvent(change.Event, now)
+			changed = true
+		case calendar.ActionDelete:
+			slog.Info("Deleting", "reason", change.Reason, "summary", change.Title, "start", change.Start, "end", change.End)
+			delete(events, change.ID)
+			changed = true
+		}
+	}
+
+	if !changed {
+		slog.Debug("Nothing changed, not writing the file", "calendar", c.String())
+		return nil
+	}
+
+	c.written = events
+	if err := c.write(c.sortedWritten()); err != nil {
+		return fmt.Errorf("writing %s: %w", c.path, err)
+	}
+	slog.Info("Wrote ICS file", "calendar", c.String(), "path", c.path, "events", len(events))
+
+	return nil
+}
+
+// write replaces the file atomically, readers see either the old or the new file
+func (c *Calendar) write(events []ics.VEvent) error {
+	var data bytes.Buffer
+	if err := ics.Encode(&data, events); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	// Permissions given to the file are kept
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(c.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data.Bytes(), mode); err != nil {
+		return err
+	}
+	// WriteFile only applies mode to new files, and the umask
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+// sortedWritten returns the written events by start time, then UID
+func (c *Calendar) sortedWritten() []ics.VEvent {
+	events := make([]ics.VEvent, 0, len(c.written))
+	for _, v := range c.written {
+		events = append(events, v)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Event.Start.Equal(events[j].Event.Start) {
+			return events[i].Event.Start.Before(events[j].Event.Start)
+		}
+		return events[i].UID < events[j].UID
+	})
+	return events
+}
+
+func newVEvent(event calendar.Event, stamp time.Time) ics.VEvent {
+	return ics.VEvent{
+		UID:   ics.UID(event),
+		Event: event,
+		Stamp: stamp,
+		Extra: ics.SourceProperties(event),
+	}
+}
+
+// writtenChange describes a change to an event that is in the file
+func writtenChange(action calendar.Action, v ics.VEvent, reason string) calendar.Change {
+	change := localChange(action, v.Event, reason)
+	change.ID = v.UID
+	change.Source = v.Event.Calendar
+	change.Event = calendar.Event{}
+	return change
+}
+
+// localChange describes a change that writes a source event to the file
+func localChange(action calendar.Action, event calendar.Event, reason string) calendar.Change {
+	change := calendar.Change{
+		Action: action,
+		Title:  event.Title,
+		Start:  event.Start.Format(time.RFC3339),
+		End:    event.Stop.Format(time.RFC3339),
+		Reason: reason,
+		Event:  event,
+	}
+	if event.AllDay {
+		change.Start = event.Start.Format(calendar.DateLayout)
+		change.End = event.Stop.Format(calendar.DateLayout)
+	}
+	return change
+}
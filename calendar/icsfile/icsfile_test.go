@@ -0,0 +1,151 @@
+package icsfile
+
+import (
+	"calsync/calendar"
+	"calsync/calendar/ics"
+	"calsync/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gocal "github.com/apognu/gocal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCalendar(t *testing.T) *Calendar {
+	t.Helper()
+	return New(config.ICSFile{Name: "test", Path: filepath.Join(t.TempDir(), "out", "calendar.ics")})
+}
+
+func testEvents(t *testing.T) []calendar.Event {
+	t.Helper()
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	standup := time.Date(2026, 5, 4, 9, 30, 0, 0, berlin)
+	return []calendar.Event{
+		{Title: "Standup", Notes: "Agenda:\nnone", Start: standup, Stop: standup.Add(15 * time.Minute), UID: "standup@example.com", Calendar: "ICS Calendar: team"},
+		{Title: "Offsite", Start: time.Date(2026, 5, 6, 0, 0, 0, 0, time.UTC), Stop: time.Date(2026, 5, 8, 0, 0, 0, 0, time.UTC), UID: "offsite@example.com", AllDay: true, Calendar: "ICS Calendar: offsites"},
+	}
+}
+
+func readEvents(t *testing.T, path string) []gocal.Event {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	start, end := time.Time{}, time.Now().AddDate(10, 0, 0)
+	parser := gocal.NewParser(strings.NewReader(string(data)))
+	parser.Start, parser.End = &start, &end
+	parser.SkipBounds = true
+	require.NoError(t, parser.Parse())
+	return parser.Events
+}
+
+func TestSyncToDest(t *testing.T) {
+	cal := newTestCalendar(t)
+	events := testEvents(t)
+
+	require.NoError(t, cal.SyncToDest(events))
+
+	data, err := os.ReadFile(cal.path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\n")
+	assert.Contains(t, string(data), "DTSTART;TZID=Europe/Berlin:20260504T093000\r\n")
+	assert.NoFileExists(t, cal.path+".tmp")
+
+	written := readEvents(t, cal.path)
+	require.Len(t, written, 2)
+	assert.Equal(t, ics.UID(events[0]), written[0].Uid)
+	assert.Equal(t, "Standup", written[0].Summary)
+	assert.Equal(t, "Offsite", written[1].Summary)
+
+	// Nothing changed, the file is left as it is, DTSTAMP included
+	info, err := os.Stat(cal.path)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	plan, err := cal.PlanSync(testEvents(t))
+	require.NoError(t, err)
+	assert.Equal(t, 2, plan.Count(calendar.ActionSkip))
+	require.NoError(t, cal.ApplyPlan(plan))
+	after, err := os.Stat(cal.path)
+	require.NoError(t, err)
+	assert.Equal(t, info.ModTime(), after.ModTime(), "An unchanged file must not be rewritten")
+
+	// An event was rescheduled, one is gone and one is new
+	events = testEvents(t)
+	events[0].Title = "Standup (moved)"
+	events[0].Start, events[0].Stop = events[0].Start.Add(30*time.Minute), events[0].Stop.Add(30*time.Minute)
+	retro := events[0]
+	retro.Title, retro.UID = "Retro", "retro@example.com"
+	retro.Start, retro.Stop = retro.Start.Add(time.Hour), retro.Stop.Add(time.Hour)
+	events = []calendar.Event{events[0], retro}
+
+	plan, err = cal.PlanSync(events)
+	require.NoError(t, err)
+	assert.Equal(t, 1, plan.Count(calendar.ActionUpdate))
+	assert.Equal(t, 1, plan.Count(calendar.ActionDelete))
+	assert.Equal(t, 1, plan.Count(calendar.ActionCreate))
+	require.NoError(t, cal.ApplyPlan(plan))
+
+	written = readEvents(t, cal.path)
+	require.Len(t, written, 2)
+	assert.Equal(t, "Standup (moved)", written[0].Summary)
+	assert.Equal(t, ics.UID(testEvents(t)[0]), written[0].Uid, "UIDs must be stable across changes")
+	assert.Equal(t, "Retro", written[1].Summary)
+}
+
+func TestSyncToDestPreserve(t *testing.T) {
+	cal := newTestCalendar(t)
+	events := testEvents(t)
+	require.NoError(t, cal.SyncToDest(events))
+
+	// The offsites source failed, its event must stay in the file
+	plan, err := cal.PlanSync(events[:1])
+	require.NoError(t, err)
+	plan = plan.Preserve([]string{"ICS Calendar: offsites"})
+	assert.Equal(t, 1, plan.Count(calendar.ActionPreserve))
+	require.NoError(t, cal.ApplyPlan(plan))
+
+	written := readEvents(t, cal.path)
+	require.Len(t, written, 2)
+	assert.Equal(t, "Offsite", written[1].Summary)
+	assert.Equal(t, "ICS Calendar: offsites", ics.UnescapeText(written[1].CustomAttributes[ics.PropSource]))
+}
+
+func TestSyncToDestForeignFile(t *testing.T) {
+	cal := newTestCalendar(t)
+	require.NoError(t, os.MkdirAll(filepath.Dir(cal.path), 0755))
+	foreign := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//someone//else//EN\r\nEND:VCALENDAR\r\n"
+	require.NoError(t, os.WriteFile(cal.path, []byte(foreign), 0600))
+
+	err := cal.SyncToDest(testEvents(t))
+	assert.ErrorContains(t, err, "not written by calsync")
+
+	data, err := os.ReadFile(cal.path)
+	require.NoError(t, err)
+	assert.Equal(t, foreign, string(data))
+}
+
+func TestPlanDeleteAll(t *testing.T) {
+	cal := newTestCalendar(t)
+	now := time.Now().Truncate(time.Second)
+	events := []calendar.Event{
+		{Title: "Soon", Start: now.Add(time.Hour), Stop: now.Add(2 * time.Hour), UID: "soon@example.com"},
+		{Title: "Later", Start: now.AddDate(0, 0, 30), Stop: now.AddDate(0, 0, 30).Add(time.Hour), UID: "later@example.com"},
+	}
+	require.NoError(t, cal.SyncToDest(events))
+
+	plan, err := cal.PlanDeleteAll(7)
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 1)
+	assert.Equal(t, "Soon", plan.Changes[0].Title)
+
+	require.NoError(t, cal.ApplyPlan(plan))
+	written := readEvents(t, cal.path)
+	require.Len(t, written, 1)
+	assert.Equal(t, "Later", written[0].Summary)
+}
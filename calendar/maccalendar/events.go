@@ -3,28 +3,37 @@ package maccalendar
 import (
 	"bufio"
 	"calsync/calendar"
-	"errors"
+	"context"
 	"fmt"
 	"log/slog"
 	"os/exec"
+	"regexp"
 	"strings"
 	"time"
 )
 
-var ErrFullDayEvent = fmt.Errorf("full day event")
-
 const (
 	timeLayout      = "Jan 2, 2006 15:04 -0700"
+	dateLayout      = "Jan 2, 2006"
+	attendeesPrefix = "    attendees: "
+	uidPrefix       = "    uid: "
 	iCalBulletPoint = "→"
 )
 
-func getEvents(iCalBuddyBinary string, calName string, start time.Time, end time.Time) ([]calendar.Event, error) {
-	output, err := getSourceRaw(iCalBuddyBinary, calName, start, end)
+func getEvents(ctx context.Context, iCalBuddyBinary string, calName string, start time.Time, end time.Time) ([]calendar.Event, error) {
+	output, err := getSourceRaw(ctx, iCalBuddyBinary, calName, start, end)
 	if err != nil {
 		return nil, fmt.Errorf("getting source raw: %s", err)
 	}
 
+	return parseEvents(output)
+}
+
+// parseEvents parses the events icalBuddy printed
+func parseEvents(output string) ([]calendar.Event, error) {
 	events := make([]calendar.Event, 0)
+	// instances counts the events printed per UID
+	instances := make(map[string]int)
 
 	for _, multilineEvent := range strings.Split(output, iCalBulletPoint) {
 		slog.Debug("Parsing event", "event", multilineEvent)
@@ -35,25 +44,33 @@ func getEvents(iCalBuddyBinary string, calName string, start time.Time, end time
 		}
 
 		event, err := getEvent(multilineEvent)
-		if errors.Is(err, ErrFullDayEvent) {
-			slog.Debug("Skipped due to full day event")
+		if err != nil {
+			// One event icalBuddy printed in a way we don't understand mustn't fail the source
+			slog.Warn("Skipping event that couldn't be parsed", "title", event.Title, "error", err)
+			slog.Debug("Unparsed event", "event", multilineEvent)
 			continue
 		}
+		instances[event.UID]++
+		events = append(events, event)
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("parsing event %s, got error %w", multilineEvent, err)
+	// icalBuddy doesn't tell recurring events apart, but prints all their instances with
+	// the same UID. Their start is the best there is to tell which instance they are,
+	// one-off events keep a zero RecurrenceID so that moving them keeps their UID.
+	for i := range events {
+		if instances[events[i].UID] > 1 {
+			events[i].RecurrenceID = events[i].Start
 		}
-		events = append(events, event)
 	}
 
 	return events, nil
 }
 
-func getSourceRaw(icalBuddyBinary string, calName string, start time.Time, end time.Time) (string, error) {
-	cmd := exec.Command(icalBuddyBinary, []string{
+func getSourceRaw(ctx context.Context, icalBuddyBinary string, calName string, start time.Time, end time.Time) (string, error) {
+	// icalBuddy is killed when ctx is done, e.g. when it hangs waiting for calendar access
+	cmd := exec.CommandContext(ctx, icalBuddyBinary, []string{
 		"-b",
 		iCalBulletPoint,
-		"-eep", "attendees,location",
 		"-uid",
 		"-ic", calName,
 		"-nc",
@@ -83,27 +100,59 @@ func getEvent(raw string) (calendar.Event, error) {
 	}
 	event.Title = line[:len(line)-1]
 
-	// Line 2: notes or time
+	// Line 2+: location, notes, attendees and/or other properties, then time
 	line, err = reader.ReadString('\n')
 	if err != nil {
 		return event, fmt.Errorf("reading notes: %s", err)
 	}
 
-	if strings.HasPrefix(line, "    notes: ") {
-		notesBody := strings.TrimPrefix(line, "    notes: ")
-		for {
+	for {
+		if strings.HasPrefix(line, "    location: ") {
+			event.Location = strings.TrimSuffix(strings.TrimPrefix(line, "    location: "), "\n")
+			line, err = reader.ReadString('\n')
+			if err != nil {
+				return event, fmt.Errorf("reading line after location: %s", err)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, attendeesPrefix) {
+			event.Attendees = parseAttendees(line)
 			line, err = reader.ReadString('\n')
 			if err != nil {
-				return event, fmt.Errorf("reading notes body: %s", err)
+				return event, fmt.Errorf("reading line after attendees: %s", err)
 			}
-			// We're still reading notes body
-			if strings.HasPrefix(line, "        ") {
-				notesBody += line[4:]
-			} else {
-				break
+			continue
+		}
+
+		if strings.HasPrefix(line, "    notes: ") {
+			notesBody := strings.TrimPrefix(line, "    notes: ")
+			for {
+				line, err = reader.ReadString('\n')
+				if err != nil {
+					return event, fmt.Errorf("reading notes body: %s", err)
+				}
+				// We're still reading notes body
+				if strings.HasPrefix(line, "        ") {
+					notesBody += line[4:]
+				} else {
+					break
+				}
+			}
+			event.Notes = strings.TrimSuffix(notesBody, "\n")
+			continue
+		}
+
+		// Properties we don't sync, e.g. url: or priority:
+		if isProperty(line) {
+			line, err = reader.ReadString('\n')
+			if err != nil {
+				return event, fmt.Errorf("reading line after property: %s", err)
 			}
+			continue
 		}
-		event.Notes = strings.TrimSuffix(notesBody, "\n")
+
+		break
 	}
 
 	// Time
@@ -111,8 +160,11 @@ func getEvent(raw string) (calendar.Event, error) {
 
 	atLocation := strings.Index(timeLine, " at ")
 	if atLocation == -1 {
-		slog.Debug("Event doesn't have time associated to it, skipping for now", "event", raw)
-		return event, ErrFullDayEvent
+		slog.Debug("Event doesn't have time associated to it, parsing as all-day event", "event", raw)
+		if err := parseAllDay(&event, timeLine); err != nil {
+			return event, err
+		}
+		return event, readUID(reader, &event)
 	}
 
 	startDate := timeLine[:atLocation] + " "
@@ -142,14 +194,76 @@ func getEvent(raw string) (calendar.Event, error) {
 	}
 	event.Stop = parsedTime.In(time.Local)
 
-	// Line: uid
-	line, err = reader.ReadString('\n')
+	return event, readUID(reader, &event)
+}
+
+// parseAllDay parses date-only lines, "Aug 9, 2023" or "Aug 9, 2023 - Aug 11, 2023".
+// icalBuddy prints the last day inclusively, calendar.Event wants it exclusive.
+func parseAllDay(event *calendar.Event, dateLine string) error {
+	dateParts := strings.Split(dateLine, " - ")
+
+	startDate, err := time.ParseInLocation(dateLayout, dateParts[0], time.Local)
 	if err != nil {
-		return event, fmt.Errorf("reading uid: %s", err)
+		return fmt.Errorf("parsing all-day start date: %s", err)
+	}
+
+	lastDate := startDate
+	if len(dateParts) > 1 {
+		lastDate, err = time.ParseInLocation(dateLayout, dateParts[1], time.Local)
+		if err != nil {
+			return fmt.Errorf("parsing all-day stop date: %s", err)
+		}
+	}
+
+	event.AllDay = true
+	event.Start = startDate
+	event.Stop = lastDate.AddDate(0, 0, 1)
+
+	return nil
+}
+
+// readUID reads the "uid: " line that follows the time line, attendees may come before it
+func readUID(reader *bufio.Reader, event *calendar.Event) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("reading uid: %s", err)
+		}
+
+		if strings.HasPrefix(line, attendeesPrefix) {
+			event.Attendees = parseAttendees(line)
+			continue
+		}
+
+		if !strings.HasPrefix(line, uidPrefix) && isProperty(line) {
+			continue
+		}
+
+		event.UID = strings.TrimSuffix(strings.TrimPrefix(line, uidPrefix), "\n")
+
+		return nil
 	}
-	uidLine := line[:len(line)-1]
-	event.UID = uidLine[9:]
+}
+
+// propertyLine matches the "    key: value" lines icalBuddy prints the properties of events with
+var propertyLine = regexp.MustCompile(`^    [a-z]+: `)
+
+// isProperty tells property lines apart from the time line, which has no "key: " prefix
+func isProperty(line string) bool {
+	return propertyLine.MatchString(line)
+}
 
-	return event, nil
+// parseAttendees parses "attendees: Jane Doe, John Doe", icalBuddy only prints names,
+// neither emails nor response status are available.
+func parseAttendees(line string) []calendar.Attendee {
+	attendees := make([]calendar.Attendee, 0)
+	for _, name := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(line, attendeesPrefix), "\n"), ", ") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		attendees = append(attendees, calendar.Attendee{Name: name})
+	}
 
+	return attendees
 }
@@ -2,6 +2,7 @@ package maccalendar
 
 import (
 	"calsync/calendar"
+	"calsync/calendar/ics"
 	"reflect"
 	"strings"
 	"testing"
@@ -23,7 +24,9 @@ func Test_GetEvent(t *testing.T) {
 		Start string
 		Stop  string
 
-		UID string
+		Location  string
+		Attendees []string
+		UID       string
 	}
 
 	tests := []struct {
@@ -59,9 +62,38 @@ func Test_GetEvent(t *testing.T) {
 			false,
 		},
 		{
-			"to be sipped, full day event",
+			"valid - with location",
 			args{
-				event: "Cleanup day\n    notes: line1\n        line2\n    Aug 9, 2023\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n",
+				event: "Design review\n    location: Room 4B\n    notes: line1\n        line2\n    Aug 9, 2023 at 16:30 -0700 - 17:00 -0700\n    attendees: Jane Doe, John Doe\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n",
+			},
+			wantEvent{
+				Title:     "Design review",
+				Location:  "Room 4B",
+				Attendees: []string{"Jane Doe", "John Doe"},
+				Start:     "Aug 9, 2023 16:30 -0700",
+				Stop:      "Aug 9, 2023 17:00 -0700",
+				UID:       "2870243A-81F4-4276-A1E3-94F1F5B47139",
+			},
+			false,
+		},
+		{
+			"valid - with url and priority",
+			args{
+				event: "Design review\n    location: Room 4B\n    url: https://meet.example.com/abc\n    Aug 9, 2023 at 16:30 -0700 - 17:00 -0700\n    priority: high\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n",
+			},
+			wantEvent{
+				Title:    "Design review",
+				Location: "Room 4B",
+				Start:    "Aug 9, 2023 16:30 -0700",
+				Stop:     "Aug 9, 2023 17:00 -0700",
+				UID:      "2870243A-81F4-4276-A1E3-94F1F5B47139",
+			},
+			false,
+		},
+		{
+			"invalid - garbage time line",
+			args{
+				event: "Cleanup day\n    notes: line1\n        line2\n    sometime soon\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n",
 			},
 			wantEvent{},
 			true,
@@ -118,6 +150,19 @@ func Test_GetEvent(t *testing.T) {
 				UID:   tt.want.UID,
 			}
 
+			if got.Location != tt.want.Location {
+				t.Errorf("getEvent() Location = %q, want %q", got.Location, tt.want.Location)
+			}
+
+			if len(got.Attendees) != len(tt.want.Attendees) {
+				t.Errorf("getEvent() Attendees = %v, want %v", got.Attendees, tt.want.Attendees)
+			}
+			for i, attendee := range got.Attendees {
+				if i < len(tt.want.Attendees) && attendee.Name != tt.want.Attendees[i] {
+					t.Errorf("getEvent() Attendee %d = %q, want %q", i, attendee.Name, tt.want.Attendees[i])
+				}
+			}
+
 			// Compare, ignoring timezone, CI and local time can be different
 			if !reflect.DeepEqual(got.String(), wantEvent.String()) {
 				t.Errorf("getEvent() = %v, want %v", got, wantEvent)
@@ -125,3 +170,104 @@ func Test_GetEvent(t *testing.T) {
 		})
 	}
 }
+
+func Test_GetEventAllDay(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     string
+		wantStart time.Time
+		wantStop  time.Time
+		wantErr   bool
+	}{
+		{
+			"single day",
+			"Cleanup day\n    notes: line1\n        line2\n    Aug 9, 2023\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n",
+			time.Date(2023, 8, 9, 0, 0, 0, 0, time.Local),
+			time.Date(2023, 8, 10, 0, 0, 0, 0, time.Local),
+			false,
+		},
+		{
+			"multiple days",
+			"Offsite\n    Aug 9, 2023 - Aug 11, 2023\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n",
+			time.Date(2023, 8, 9, 0, 0, 0, 0, time.Local),
+			time.Date(2023, 8, 12, 0, 0, 0, 0, time.Local),
+			false,
+		},
+		{
+			"invalid stop date",
+			"Offsite\n    Aug 9, 2023 - someday\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n",
+			time.Time{},
+			time.Time{},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getEvent(tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getEvent() got = %#v, error = %v, wantErr %v", got, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !got.AllDay {
+				t.Errorf("getEvent() AllDay = false, want true")
+			}
+			if !got.Start.Equal(tt.wantStart) || !got.Stop.Equal(tt.wantStop) {
+				t.Errorf("getEvent() = %v - %v, want %v - %v", got.Start, got.Stop, tt.wantStart, tt.wantStop)
+			}
+			if got.UID != "2870243A-81F4-4276-A1E3-94F1F5B47139" {
+				t.Errorf("getEvent() UID = %s", got.UID)
+			}
+		})
+	}
+}
+
+func Test_ParseEventsUID(t *testing.T) {
+	dentist := "→Dentist\n    Aug 9, 2023 at 16:30 -0700 - 17:00 -0700\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n"
+	moved := "→Dentist\n    Aug 10, 2023 at 09:00 -0700 - 09:30 -0700\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n"
+	standups := "→Standup\n    Aug 9, 2023 at 09:00 -0700 - 09:15 -0700\n    uid: 9D2C7A51-2F4B-4C59-9E0B-0C1F3B9A6D11\n" +
+		"→Standup\n    Aug 10, 2023 at 09:00 -0700 - 09:15 -0700\n    uid: 9D2C7A51-2F4B-4C59-9E0B-0C1F3B9A6D11\n"
+
+	before, err := parseEvents(dentist + standups)
+	if err != nil {
+		t.Fatalf("parseEvents() error = %v", err)
+	}
+	after, err := parseEvents(moved + standups)
+	if err != nil {
+		t.Fatalf("parseEvents() error = %v", err)
+	}
+	if len(before) != 3 || len(after) != 3 {
+		t.Fatalf("parseEvents() got %d and %d events, want 3", len(before), len(after))
+	}
+
+	if !before[0].RecurrenceID.IsZero() {
+		t.Errorf("One-off event RecurrenceID = %v, want zero", before[0].RecurrenceID)
+	}
+	if ics.UID(before[0]) != ics.UID(after[0]) {
+		t.Errorf("Rescheduled one-off event UID changed from %s to %s", ics.UID(before[0]), ics.UID(after[0]))
+	}
+
+	if !before[1].RecurrenceID.Equal(before[1].Start) {
+		t.Errorf("Recurring instance RecurrenceID = %v, want its start %v", before[1].RecurrenceID, before[1].Start)
+	}
+	if ics.UID(before[1]) == ics.UID(before[2]) {
+		t.Errorf("Instances of a recurring event got the same UID %s", ics.UID(before[1]))
+	}
+}
+
+func Test_ParseEventsSkipsUnparsable(t *testing.T) {
+	output := "→Dentist\n    Aug 9, 2023 at 16:30 -0700 - 17:00 -0700\n    uid: 2870243A-81F4-4276-A1E3-94F1F5B47139\n" +
+		"→Cleanup day\n    sometime soon\n    uid: 9D2C7A51-2F4B-4C59-9E0B-0C1F3B9A6D11\n" +
+		"→Offsite\n    url: https://example.com/offsite\n    Aug 10, 2023\n    uid: 5B1E0C2A-7D3F-4A8B-9C6D-2E4F6A8B0C1D\n"
+
+	events, err := parseEvents(output)
+	if err != nil {
+		t.Fatalf("parseEvents() error = %v, an unparsable event mustn't fail the source", err)
+	}
+	if len(events) != 2 || events[0].Title != "Dentist" || events[1].Title != "Offsite" {
+		t.Errorf("parseEvents() = %v, want Dentist and Offsite", events)
+	}
+}
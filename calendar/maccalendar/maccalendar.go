@@ -26,10 +26,10 @@ func (c *Calendar) String() string {
 	return fmt.Sprintf("Mac Calendar: %s", c.calName)
 }
 
-func (c *Calendar) GetEvents(start time.Time, end time.Time) ([]calendar.Event, error) {
-	events, err := getEvents(c.iCalBuddyBinary, c.calName, start, end)
+func (c *Calendar) GetEvents(ctx context.Context, start time.Time, end time.Time) ([]calendar.Event, error) {
+	events, err := getEvents(ctx, c.iCalBuddyBinary, c.calName, start, end)
 	if err != nil {
-		return nil, fmt.Errorf("getting events from mac calendar: %s", err)
+		return nil, fmt.Errorf("getting events from mac calendar %s: %s", c.calName, err)
 	}
 
 	return events, nil
@@ -40,3 +40,12 @@ func (c *Calendar) PutEvents() error      { return nil }
 func (c *Calendar) SyncToDest([]calendar.Event) error {
 	return fmt.Errorf("SyncToDest not implemented for Mac calendar")
 }
+func (c *Calendar) PlanSync([]calendar.Event) (calendar.Plan, error) {
+	return calendar.Plan{}, fmt.Errorf("PlanSync not implemented for Mac calendar")
+}
+func (c *Calendar) ApplyPlan(calendar.Plan) error {
+	return fmt.Errorf("ApplyPlan not implemented for Mac calendar")
+}
+func (c *Calendar) PlanDeleteAll(_ int) (calendar.Plan, error) {
+	return calendar.Plan{}, fmt.Errorf("PlanDeleteAll not implemented for Mac calendar")
+}
@@ -0,0 +1,108 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooManyDeletes is returned when a plan deletes more events than the safety threshold allows
+var ErrTooManyDeletes = errors.New("too many events would be deleted")
+
+// Action is what a target calendar will do with an event during a sync
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionSkip   Action = "skip"
+	// ActionIgnore is for events that aren't managed by calsync
+	ActionIgnore Action = "ignore"
+	// ActionPreserve keeps managed events of a source that failed, they'd be deleted otherwise
+	ActionPreserve Action = "preserve"
+)
+
+const reasonSourceFailed = "source failed"
+
+// Change is a single decision made by a target calendar, Reason explains it
+type Change struct {
+	Action Action `json:"action"`
+	Title  string `json:"title"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	ID     string `json:"id,omitempty"`
+	Reason string `json:"reason"`
+	// Source is the source calendar of an event already in the target, empty when unknown
+	Source string `json:"source,omitempty"`
+
+	// Event is the source event to create or update with
+	Event Event `json:"-"`
+}
+
+// Plan is the list of changes a target calendar will make, computed without
+// modifying anything.
+type Plan struct {
+	Calendar string   `json:"calendar"`
+	Changes  []Change `json:"changes"`
+}
+
+// Count returns the number of changes with the given action
+func (p Plan) Count(action Action) int {
+	n := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Managed returns the number of calsync-managed events already in the target calendar
+func (p Plan) Managed() int {
+	return p.Count(ActionSkip) + p.Count(ActionUpdate) + p.Count(ActionDelete) + p.Count(ActionPreserve)
+}
+
+// Preserve returns the plan with deletes of events from failedSources turned into
+// ActionPreserve. Events of unknown sources are preserved too, they may come from one.
+func (p Plan) Preserve(failedSources []string) Plan {
+	if len(failedSources) == 0 {
+		return p
+	}
+
+	failed := make(map[string]bool, len(failedSources))
+	for _, src := range failedSources {
+		failed[src] = true
+	}
+
+	preserved := Plan{Calendar: p.Calendar, Changes: make([]Change, 0, len(p.Changes))}
+	for _, change := range p.Changes {
+		if change.Action == ActionDelete && (change.Source == "" || failed[change.Source]) {
+			change.Action = ActionPreserve
+			change.Reason = reasonSourceFailed
+		}
+		preserved.Changes = append(preserved.Changes, change)
+	}
+
+	return preserved
+}
+
+// CheckDeletes returns ErrTooManyDeletes if the plan deletes more than maxDeletes events
+// or more than maxPercent of managed events. A zero limit disables that check.
+// The percentage only counts above minDeletesForPercent deletes, on small calendars
+// removing a single event is already a large share.
+func (p Plan) CheckDeletes(maxDeletes int, maxPercent int, minDeletesForPercent int) error {
+	deletes := p.Count(ActionDelete)
+	if deletes == 0 {
+		return nil
+	}
+
+	if maxDeletes > 0 && deletes > maxDeletes {
+		return fmt.Errorf("%w: %s would delete %d events, limit is %d", ErrTooManyDeletes, p.Calendar, deletes, maxDeletes)
+	}
+
+	if managed := p.Managed(); maxPercent > 0 && deletes > minDeletesForPercent && deletes*100 > maxPercent*managed {
+		return fmt.Errorf("%w: %s would delete %d of %d managed events, limit is %d%%", ErrTooManyDeletes, p.Calendar, deletes, managed, maxPercent)
+	}
+
+	return nil
+}
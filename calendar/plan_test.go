@@ -0,0 +1,91 @@
+package calendar
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestPlan(deletes, skips, ignores int) Plan {
+	plan := Plan{Calendar: "test"}
+	for i := 0; i < deletes; i++ {
+		plan.Changes = append(plan.Changes, Change{Action: ActionDelete})
+	}
+	for i := 0; i < skips; i++ {
+		plan.Changes = append(plan.Changes, Change{Action: ActionSkip})
+	}
+	for i := 0; i < ignores; i++ {
+		plan.Changes = append(plan.Changes, Change{Action: ActionIgnore})
+	}
+	return plan
+}
+
+func TestCheckDeletes(t *testing.T) {
+	tests := []struct {
+		name          string
+		plan          Plan
+		maxDeletes    int
+		maxPercent    int
+		minForPercent int
+		wantErr       bool
+	}{
+		{"no deletes", newTestPlan(0, 10, 0), 1, 1, 5, false},
+		{"under count limit", newTestPlan(3, 10, 0), 3, 0, 5, false},
+		{"over count limit", newTestPlan(4, 10, 0), 3, 0, 5, true},
+		{"over percent limit", newTestPlan(10, 5, 0), 0, 50, 5, true},
+		{"under percent limit", newTestPlan(10, 10, 0), 0, 50, 5, false},
+		{"unmanaged events don't count", newTestPlan(10, 5, 100), 0, 50, 5, true},
+		{"small calendars ignore percent", newTestPlan(1, 0, 0), 0, 50, 5, false},
+		{"percent ignored up to the floor", newTestPlan(5, 0, 0), 0, 50, 5, false},
+		{"percent counts above the floor", newTestPlan(6, 0, 0), 0, 50, 5, true},
+		{"lower floor", newTestPlan(2, 0, 0), 0, 50, 1, true},
+		{"limits disabled", newTestPlan(100, 0, 0), 0, 0, 5, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.plan.CheckDeletes(tt.maxDeletes, tt.maxPercent, tt.minForPercent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckDeletes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrTooManyDeletes) {
+				t.Errorf("CheckDeletes() error = %v, want ErrTooManyDeletes", err)
+			}
+		})
+	}
+}
+
+func TestPreserve(t *testing.T) {
+	plan := Plan{
+		Calendar: "test",
+		Changes: []Change{
+			{Action: ActionDelete, ID: "failed", Source: "ICS Calendar: failed"},
+			{Action: ActionDelete, ID: "healthy", Source: "ICS Calendar: healthy"},
+			{Action: ActionDelete, ID: "unknown"},
+			{Action: ActionSkip, ID: "skipped", Source: "ICS Calendar: failed"},
+		},
+	}
+
+	got := plan.Preserve([]string{"ICS Calendar: failed"})
+
+	want := map[string]Action{
+		"failed":  ActionPreserve,
+		"healthy": ActionDelete,
+		"unknown": ActionPreserve,
+		"skipped": ActionSkip,
+	}
+	for _, change := range got.Changes {
+		if change.Action != want[change.ID] {
+			t.Errorf("%s: got %s, want %s", change.ID, change.Action, want[change.ID])
+		}
+	}
+
+	if plan.Changes[0].Action != ActionDelete {
+		t.Errorf("Preserve() must not modify the original plan")
+	}
+	if got.Managed() != plan.Managed() {
+		t.Errorf("Managed() changed from %d to %d", plan.Managed(), got.Managed())
+	}
+	if same := plan.Preserve(nil); same.Count(ActionDelete) != 3 {
+		t.Errorf("Preserve() without failed sources: got %d deletes, want 3", same.Count(ActionDelete))
+	}
+}
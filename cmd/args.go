@@ -3,32 +3,63 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 type cmdArgs struct {
-	deleteDst string
+	configFile string
+	deleteDst  string
+	dryRun     bool
+	force      bool
+	output     string
+	wait       time.Duration
+	printURL   bool
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "calsync",
 	Short: "Synchronize calendar events between different calendar sources",
 	Long: `CalSync is a Go application that synchronizes calendar events between different
-calendar sources (Mac Calendar, Google Calendar, ICS feeds). It reads events
+calendar sources (Mac Calendar, Google Calendar, ICS feeds and files, CalDAV). It reads events
 from source calendars and syncs them to target calendars, helping users maintain
 a unified view across different calendar systems.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		configFile, _ := cmd.Flags().GetString("config")
 		deleteDst, _ := cmd.Flags().GetString("delete-dst")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+		output, _ := cmd.Flags().GetString("output")
+		wait, _ := cmd.Flags().GetDuration("wait")
 		cmdArgs := cmdArgs{
-			deleteDst: deleteDst,
+			configFile: configFile,
+			deleteDst:  deleteDst,
+			dryRun:     dryRun,
+			force:      force,
+			output:     output,
+			wait:       wait,
 		}
 		run(cmdArgs)
 	},
 }
 
 func Execute() {
+	rootCmd.PersistentFlags().StringP("config", "c", "", "Config file (default $XDG_CONFIG_HOME/calsync/config.toml or ~/.config/calsync/config.toml)")
 	rootCmd.Flags().StringP("delete-dst", "", "", "Delete all calsync-managed events from the specified destination calendar (e.g., 'Google')")
+	rootCmd.Flags().BoolP("dry-run", "", false, "Print what would be created, updated and deleted, without changing target calendars")
+	rootCmd.Flags().BoolP("force", "", false, "Sync even if more events would be deleted than allowed by Sync.MaxDeletes/MaxDeletePercent")
+	rootCmd.Flags().StringP("output", "o", "table", "Format of the --dry-run plan: 'table' or 'json'")
+	rootCmd.Flags().DurationP("wait", "", 0, "Wait up to this long (e.g. 5m) for another calsync run syncing the same target calendar, instead of exiting")
+
+	daemonCmd.Flags().BoolP("force", "", false, "Sync even if more events would be deleted than allowed by Sync.MaxDeletes/MaxDeletePercent")
+	daemonCmd.Flags().DurationP("wait", "", 0, "Wait up to this long (e.g. 5m) for another calsync run syncing the same target calendar, instead of skipping the sync")
+	serveCmd.Flags().BoolP("print-url", "", false, "Print the URL of the feed, which holds its secret token, to stdout on start")
+
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(serveCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -2,67 +2,245 @@ package cmd
 
 import (
 	"calsync/calendar"
+	"calsync/calendar/caldav"
 	"calsync/calendar/gcal"
 	"calsync/calendar/ics"
+	"calsync/calendar/icsfile"
 	"calsync/calendar/maccalendar"
 	"calsync/config"
+	"calsync/lock"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2/google"
 	gCalenader "google.golang.org/api/calendar/v3"
 )
 
-func syncCalendars(ctx context.Context, cfg *config.Config) {
+// syncCalendars syncs routed sources to targets, the returned exit code tells if some failed
+func syncCalendars(ctx context.Context, cfg *config.Config, cmdArgs cmdArgs) int {
 	sources, targets, err := getSourceTargetCalendars(ctx, cfg)
 	if err != nil {
 		slog.Error("Failed to get source and target calendars", "error", err)
-		os.Exit(1)
+		return exitFailure
 	}
 
-	start := time.Now().Add(-24 * time.Hour).Truncate(24 * time.Hour)
-	end := start.Add(24 * time.Hour * time.Duration(cfg.Sync.Days))
+	routes, err := newRouteTable(cfg.Routes, sources, targets)
+	if err != nil {
+		slog.Error("Failed to route source calendars to targets", "error", err)
+		return exitFailure
+	}
+
+	// Dry runs don't change targets, they can overlap with a sync
+	if cmdArgs.dryRun {
+		for _, target := range routes.targets() {
+			setDryRun(target)
+		}
+	} else {
+		release, err := lockTargets(cfg, routes.targets(), cmdArgs.wait)
+		defer release()
+		if errors.Is(err, lock.ErrLocked) {
+			slog.Error("Another calsync run is syncing the same target calendar, rerun later or pass --wait", "error", err)
+			return exitLocked
+		}
+		if err != nil {
+			slog.Error("Failed to lock target calendars", "error", err)
+			return exitFailure
+		}
+	}
+
+	start, end := syncRange(cfg.Sync)
 
 	slog.Info("Searching for events", "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339))
 
-	events, err := getSourceEventsSorted(ctx, sources, start, end)
+	events, failedSources, err := getSourceEventsSorted(ctx, routes.sources(), start, end, cfg.Sync.Timeout())
 	if err != nil {
-		slog.Error("Failed to get events from Mac calendar", "error", err)
-		os.Exit(1)
+		if len(failedSources) == len(routes.sources()) {
+			slog.Error("Failed to get events from all source calendars", "error", err)
+			return exitFailure
+		}
+		slog.Warn("Syncing healthy source calendars only, events of failed ones are preserved", "failed", failedSources)
+	}
+
+	maxDeletes, maxDeletePercent, minDeletesForPercent := cfg.Sync.DeleteLimits()
+
+	failedTargets := 0
+	planned := make([]calendar.Calendar, 0, len(targets))
+	plans := make([]calendar.Plan, 0, len(targets))
+	for _, target := range routes.targets() {
+		plan, err := target.PlanSync(routes.eventsFor(target, events))
+		if err != nil {
+			slog.Error("Failed to plan sync to target calendar", "error", err, "target", target)
+			failedTargets++
+			continue
+		}
+
+		// Events of a failed source look stale, they must survive until it's back
+		plan = plan.Preserve(routes.failedSourcesFor(target, failedSources))
+
+		// A failing or empty source would make every synced event look stale
+		if err := plan.CheckDeletes(maxDeletes, maxDeletePercent, minDeletesForPercent); err != nil {
+			if !cmdArgs.force && !cmdArgs.dryRun {
+				slog.Error("Skipping target calendar, rerun with --force if this is expected", "error", err, "target", target)
+				failedTargets++
+				continue
+			}
+			slog.Warn("Deletion safety threshold exceeded", "error", err, "force", cmdArgs.force)
+		}
+
+		planned = append(planned, target)
+		plans = append(plans, plan)
 	}
 
-	for _, target := range targets {
-		if err := target.SyncToDest(events); err != nil {
-			slog.Error("Failed to sync events to target calendar", "error", err)
-			os.Exit(1)
+	if cmdArgs.dryRun {
+		if err := printPlans(os.Stdout, plans, cmdArgs.output); err != nil {
+			slog.Error("Failed to print plan", "error", err)
+			return exitFailure
 		}
+		return exitCode(failedSources, failedTargets, len(planned))
 	}
+
+	synced := 0
+	for i, target := range planned {
+		if err := target.ApplyPlan(plans[i]); err != nil {
+			slog.Error("Failed to sync events to target calendar", "error", err, "target", target)
+			logBatchFailures(err)
+			failedTargets++
+			continue
+		}
+		synced++
+		slog.Info("Finished syncing target calendar", "target", target,
+			"created", plans[i].Count(calendar.ActionCreate),
+			"updated", plans[i].Count(calendar.ActionUpdate),
+			"deleted", plans[i].Count(calendar.ActionDelete),
+			"preserved", plans[i].Count(calendar.ActionPreserve),
+			"retries", retriesOf(target))
+	}
+
+	return exitCode(failedSources, failedTargets, synced)
 }
 
-func getSourceEventsSorted(_ context.Context, sources []calendar.Calendar, start time.Time, end time.Time) ([]calendar.Event, error) {
+// syncRange returns the time range events are synced in, from yesterday for Sync.Days
+func syncRange(sync config.Sync) (time.Time, time.Time) {
+	start := time.Now().Add(-24 * time.Hour).Truncate(24 * time.Hour)
+	return start, start.Add(24 * time.Hour * time.Duration(sync.Days))
+}
+
+// exitCode returns exitPartial when some sources or targets failed but at least
+// one target was synced, and exitFailure when none was
+func exitCode(failedSources []string, failedTargets int, synced int) int {
+	if len(failedSources) == 0 && failedTargets == 0 {
+		return 0
+	}
+
+	if synced == 0 {
+		slog.Error("Sync failed, no target calendar was synced", "failed_sources", len(failedSources), "failed_targets", failedTargets)
+		return exitFailure
+	}
+
+	slog.Warn("Sync partially failed", "failed_sources", len(failedSources), "failed_targets", failedTargets, "synced_targets", synced)
+	return exitPartial
+}
+
+// logBatchFailures logs each change a target rejected, so that failed events can be told apart
+func logBatchFailures(err error) {
+	var batchErr *gcal.BatchError
+	if !errors.As(err, &batchErr) {
+		return
+	}
+
+	for _, failure := range batchErr.Failures {
+		slog.Error("Change failed", "action", failure.Change.Action, "summary", failure.Change.Title,
+			"start", failure.Change.Start, "end", failure.Change.End, "error", failure.Err)
+	}
+}
+
+// dryRunner is implemented by targets that keep state which planning alone mustn't change
+type dryRunner interface {
+	DryRun()
+}
+
+// setDryRun keeps planning on cal from changing the state its next sync sees
+func setDryRun(cal calendar.Calendar) {
+	if d, ok := cal.(dryRunner); ok {
+		d.DryRun()
+	}
+}
+
+// retryCounter is implemented by targets that retry rate limited API calls
+type retryCounter interface {
+	Retries() int
+}
+
+func retriesOf(cal calendar.Calendar) int {
+	if r, ok := cal.(retryCounter); ok {
+		return r.Retries()
+	}
+	return 0
+}
+
+// getSourceEventsSorted fetches all sources concurrently, each one within timeout. Events of
+// healthy sources are returned even when others failed, along with the String() of failed
+// sources and their errors joined, so that each of them can be told apart.
+func getSourceEventsSorted(ctx context.Context, sources []calendar.Calendar, start time.Time, end time.Time, timeout time.Duration) ([]calendar.Event, []string, error) {
+	results := make([][]calendar.Event, len(sources))
+	errs := make([]error, len(sources))
+
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			srcCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			events, err := src.GetEvents(srcCtx, start, end)
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("timed out after %s: %w", timeout, err)
+			}
+			if err != nil {
+				slog.Error("Couldn't get events from source calendar", "source", src.String(), "error", err)
+				errs[i] = fmt.Errorf("%s: %w", src, err)
+				return
+			}
+
+			slog.Info("Got events from source calendar", "source", src.String(), "count", len(events))
+			for j := range events {
+				events[j].Calendar = src.String()
+			}
+			results[i] = events
+		}()
+	}
+	wg.Wait()
+
+	failed := make([]string, 0)
 	allEvents := make([]calendar.Event, 0)
-	for _, src := range sources {
-		events, err := src.GetEvents(start, end)
-		if err != nil {
-			return nil, fmt.Errorf("Couldn't get list of events from source calendar: %s", err)
+	for i, events := range results {
+		if errs[i] != nil {
+			failed = append(failed, sources[i].String())
+			continue
 		}
 		allEvents = append(allEvents, events...)
 	}
 
 	calendar.Events(allEvents).SortStartTime()
 
-	return allEvents, nil
+	return allEvents, failed, errors.Join(errs...)
 }
 
-func newGoogleClient(ctx context.Context, cfg *config.Config) (*gcal.Client, error) {
-	b, err := os.ReadFile(cfg.CredentialsFile())
+func newGoogleClient(ctx context.Context, cfg *config.Config, gCfg *config.Google) (*gcal.Client, error) {
+	slog.Info("Using Google OAuth files", "calendar", gCfg.Id, "credentials", gCfg.CredentialsFile(), "token", gCfg.TokenFile())
+
+	b, err := os.ReadFile(gCfg.CredentialsFile())
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read client secret file, location: %s: err: %w", cfg.CredentialsFile(), err)
+		return nil, fmt.Errorf("Unable to read client secret file, location: %s: err: %w", gCfg.CredentialsFile(), err)
 	}
 
 	oAuthCfg, err := google.ConfigFromJSON(b, gCalenader.CalendarScope)
@@ -70,7 +248,7 @@ func newGoogleClient(ctx context.Context, cfg *config.Config) (*gcal.Client, err
 		return nil, fmt.Errorf("Unable to parse client secret file to oAuthCfg: %v", err)
 	}
 
-	client, err := gcal.New(ctx, *cfg.Target.Google, oAuthCfg)
+	client, err := gcal.New(ctx, *gCfg, cfg.Sync, oAuthCfg)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get initialize gcal: %s", err)
 	}
@@ -78,7 +256,23 @@ func newGoogleClient(ctx context.Context, cfg *config.Config) (*gcal.Client, err
 	return client, nil
 }
 
-func getSourceTargetCalendars(ctx context.Context, cfg *config.Config) ([]calendar.Calendar, []calendar.Calendar, error) {
+// configuredCalendar is a calendar along with the names it can be referred to by in config
+type configuredCalendar struct {
+	calendar.Calendar
+
+	// typ is the lower-cased config type, e.g. "ical"
+	typ string
+	// name is the configured Name, can be empty
+	name string
+}
+
+// matches checks if name refers to this calendar, either by type or by Name
+func (c configuredCalendar) matches(name string) bool {
+	name = strings.ToLower(name)
+	return name == c.typ || (c.name != "" && strings.ToLower(c.name) == name)
+}
+
+func getSourceTargetCalendars(ctx context.Context, cfg *config.Config) ([]configuredCalendar, []configuredCalendar, error) {
 	sources, err := getCalendarsFor(ctx, cfg, cfg.Source)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Failed to get source calendars: %s", err)
@@ -100,20 +294,28 @@ func getSourceTargetCalendars(ctx context.Context, cfg *config.Config) ([]calend
 	return sources, targets, nil
 }
 
-func getCalendarsFor(ctx context.Context, cfg *config.Config, typ config.Calendars) ([]calendar.Calendar, error) {
-	var calendars []calendar.Calendar
+func getCalendarsFor(ctx context.Context, cfg *config.Config, typ config.Calendars) ([]configuredCalendar, error) {
+	var calendars []configuredCalendar
 
 	v := reflect.ValueOf(typ)
+	t := reflect.TypeOf(typ)
 
 	for i := 0; i < v.NumField(); i++ {
-		fieldValue := v.Field(i).Interface()
+		list := v.Field(i)
+		for j := 0; j < list.Len(); j++ {
+			fieldValue := list.Index(j).Interface()
 
-		cal, err := initializeCalendar(ctx, cfg, fieldValue)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to initialize calendar client: %w", err)
-		}
-		if cal != nil {
-			calendars = append(calendars, cal)
+			cal, err := initializeCalendar(ctx, cfg, fieldValue)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to initialize calendar client: %w", err)
+			}
+			if cal != nil {
+				calendars = append(calendars, configuredCalendar{
+					Calendar: cal,
+					typ:      strings.ToLower(t.Field(i).Name),
+					name:     calendarNameOf(fieldValue),
+				})
+			}
 		}
 	}
 
@@ -132,12 +334,39 @@ func initializeCalendar(ctx context.Context, cfg *config.Config, fieldValue inte
 		}
 	case *config.Google:
 		if concrete != nil && concrete.Enabled {
-			return newGoogleClient(ctx, cfg)
+			return newGoogleClient(ctx, cfg, concrete)
+		}
+	case *config.CalDAV:
+		if concrete != nil && concrete.Enabled {
+			return caldav.New(ctx, *concrete)
+		}
+	case *config.ICSFile:
+		if concrete != nil && concrete.Enabled {
+			return icsfile.New(*concrete), nil
 		}
 	}
 	return nil, nil
 }
 
+// calendarNameOf returns the configured Name of the calendar, empty if it has none
+func calendarNameOf(fieldValue interface{}) string {
+	switch concrete := fieldValue.(type) {
+	case *config.Mac:
+		return concrete.Name
+	case *config.ICal:
+		return concrete.Name
+	case *config.Google:
+		return concrete.Name
+	case *config.CalDAV:
+		return concrete.Name
+	case *config.ICSFile:
+		return concrete.Name
+	}
+	return ""
+}
+
+// getCalendarByName returns the first enabled calendar, whose type (e.g. "google")
+// or configured Name matches calendarName.
 func getCalendarByName(ctx context.Context, cfg *config.Config, calendarName string) (calendar.Calendar, error) {
 	// Check both source and target calendars
 	for _, calendars := range []config.Calendars{cfg.Source, cfg.Target} {
@@ -146,10 +375,16 @@ func getCalendarByName(ctx context.Context, cfg *config.Config, calendarName str
 
 		for i := 0; i < v.NumField(); i++ {
 			fieldName := strings.ToLower(t.Field(i).Name)
-			fieldValue := v.Field(i).Interface()
+			list := v.Field(i)
+
+			for j := 0; j < list.Len(); j++ {
+				fieldValue := list.Index(j).Interface()
+
+				// Match the calendar name with the field name or the calendar's Name
+				if fieldName != calendarName && strings.ToLower(calendarNameOf(fieldValue)) != calendarName {
+					continue
+				}
 
-			// Match the calendar name with the field name
-			if fieldName == calendarName {
 				cal, err := initializeCalendar(ctx, cfg, fieldValue)
 				if err != nil {
 					return nil, fmt.Errorf("failed to initialize %s calendar: %w", calendarName, err)
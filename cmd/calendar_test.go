@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"calsync/calendar"
+	"calsync/lock"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSourceEventsSorted(t *testing.T) {
+	start := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	at := func(hour int) time.Time { return start.Add(time.Duration(hour) * time.Hour) }
+
+	oncall := &fakeCalendar{name: "oncall", events: []calendar.Event{{Title: "Pager", Start: at(10)}, {Title: "Handover", Start: at(8)}}}
+	offsites := &fakeCalendar{name: "offsites", events: []calendar.Event{{Title: "Offsite", Start: at(9)}}}
+	broken := &fakeCalendar{name: "broken", err: errors.New("502 Bad Gateway")}
+	slow := &fakeCalendar{name: "slow", events: []calendar.Event{{Title: "Late", Start: at(1)}}, delay: time.Hour}
+
+	tests := []struct {
+		name       string
+		sources    []calendar.Calendar
+		wantTitles []string
+		wantFailed []string
+		wantErrs   []string
+	}{
+		{
+			name:       "healthy",
+			sources:    []calendar.Calendar{oncall, offsites},
+			wantTitles: []string{"Handover", "Offsite", "Pager"},
+			wantFailed: []string{},
+		},
+		{
+			name:       "failed source",
+			sources:    []calendar.Calendar{oncall, broken, offsites},
+			wantTitles: []string{"Handover", "Offsite", "Pager"},
+			wantFailed: []string{"broken"},
+			wantErrs:   []string{"broken: 502 Bad Gateway"},
+		},
+		{
+			name:       "timed out source",
+			sources:    []calendar.Calendar{slow, oncall},
+			wantTitles: []string{"Handover", "Pager"},
+			wantFailed: []string{"slow"},
+			wantErrs:   []string{"slow: timed out after 50ms"},
+		},
+		{
+			name:       "all failed",
+			sources:    []calendar.Calendar{broken, slow},
+			wantTitles: []string{},
+			wantFailed: []string{"broken", "slow"},
+			wantErrs:   []string{"broken: 502 Bad Gateway", "slow: timed out after 50ms"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			began := time.Now()
+			events, failed, err := getSourceEventsSorted(context.Background(), tt.sources, start, at(24), 50*time.Millisecond)
+			assert.Less(t, time.Since(began), 5*time.Second, "A slow source must time out")
+
+			if len(tt.wantErrs) == 0 {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				for _, want := range tt.wantErrs {
+					assert.ErrorContains(t, err, want)
+				}
+			}
+			assert.Equal(t, tt.wantFailed, failed)
+
+			titles := make([]string, 0, len(events))
+			for _, event := range events {
+				titles = append(titles, event.Title)
+				assert.NotEmpty(t, event.Calendar, "Events must be tagged with their source")
+			}
+			assert.Equal(t, tt.wantTitles, titles)
+		})
+	}
+}
+
+func TestGetSourceEventsSortedTagsSource(t *testing.T) {
+	oncall := &fakeCalendar{name: "oncall", events: []calendar.Event{{Title: "Pager"}}}
+	offsites := &fakeCalendar{name: "offsites", events: []calendar.Event{{Title: "Offsite", Start: time.Now()}}}
+
+	events, _, err := getSourceEventsSorted(context.Background(), []calendar.Calendar{oncall, offsites}, time.Now(), time.Now(), time.Second)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "oncall", events[0].Calendar)
+	assert.Equal(t, "offsites", events[1].Calendar)
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name          string
+		failedSources []string
+		failedTargets int
+		synced        int
+		want          int
+	}{
+		{name: "all synced", synced: 2, want: 0},
+		{name: "failed source", failedSources: []string{"oncall"}, synced: 2, want: exitPartial},
+		{name: "failed target", failedTargets: 1, synced: 1, want: exitPartial},
+		{name: "no target synced", failedTargets: 2, want: exitFailure},
+		{name: "failed source and no target synced", failedSources: []string{"oncall"}, failedTargets: 1, want: exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exitCode(tt.failedSources, tt.failedTargets, tt.synced))
+		})
+	}
+}
+
+const syncTestConfig = `
+[Sync]
+Days = 7
+
+[[Source.ICal]]
+Enabled = true
+Name = "oncall"
+URL = "oncall.ics"
+
+[[Source.ICal]]
+Enabled = true
+Name = "offsites"
+URL = "offsites.ics"
+
+[[Target.ICSFile]]
+Enabled = true
+Name = "khal"
+Path = "synced.ics"
+`
+
+// writeICS writes an .ics file with a single event starting tomorrow
+func writeICS(t *testing.T, path string, title string) {
+	t.Helper()
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Hour)
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:calsync test\r\nBEGIN:VEVENT\r\n" +
+		"UID:" + title + "@example.com\r\nDTSTAMP:20260101T000000Z\r\n" +
+		"DTSTART:" + start.Format("20060102T150405Z") + "\r\nDTEND:" + start.Add(time.Hour).Format("20060102T150405Z") + "\r\n" +
+		"SUMMARY:" + title + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	require.NoError(t, os.WriteFile(path, []byte(ics), 0600))
+}
+
+func TestSyncCalendarsExitCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []string
+		locked  bool
+		want    int
+	}{
+		{name: "healthy", sources: []string{"oncall", "offsites"}, want: 0},
+		{name: "failed source", sources: []string{"oncall"}, want: exitPartial},
+		{name: "all sources failed", want: exitFailure},
+		{name: "locked target", sources: []string{"oncall", "offsites"}, locked: true, want: exitLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+
+			configFile := filepath.Join(dir, "config.toml")
+			require.NoError(t, os.WriteFile(configFile, []byte(syncTestConfig), 0600))
+			for _, src := range tt.sources {
+				writeICS(t, filepath.Join(dir, src+".ics"), src)
+			}
+
+			cfg, err := loadConfig(configFile)
+			require.NoError(t, err)
+
+			if tt.locked {
+				_, targets, err := getSourceTargetCalendars(context.Background(), cfg)
+				require.NoError(t, err)
+				l, err := lock.Acquire(lockFile(cfg, targets[0].Calendar))
+				require.NoError(t, err)
+				defer l.Release()
+			}
+
+			assert.Equal(t, tt.want, syncCalendars(context.Background(), cfg, cmdArgs{}))
+
+			synced, err := os.ReadFile(filepath.Join(dir, "synced.ics"))
+			if tt.want != 0 && tt.want != exitPartial {
+				assert.True(t, os.IsNotExist(err), "The target must be untouched")
+				return
+			}
+			require.NoError(t, err)
+			for _, src := range tt.sources {
+				assert.Contains(t, string(synced), "SUMMARY:"+src+"\r\n")
+			}
+		})
+	}
+}
@@ -1,13 +1,17 @@
 package cmd
 
 import (
+	"calsync/calendar"
 	"calsync/config"
+	"calsync/lock"
 	versioncheck "calsync/version"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 )
 
 var (
@@ -16,6 +20,14 @@ var (
 	Date    = "unknown"
 )
 
+const (
+	exitFailure = 1
+	// exitPartial means some sources or targets failed, the healthy ones were synced
+	exitPartial = 3
+	// exitLocked means another calsync run was syncing the same target calendar
+	exitLocked = 4
+)
+
 func run(cmdArgs cmdArgs) {
 	setupLogging()
 
@@ -23,16 +35,26 @@ func run(cmdArgs cmdArgs) {
 
 	ctx := context.Background()
 
-	cfg, err := config.GetConfig(os.Getenv("HOME") + "/.config/calsync/config.toml")
+	cfg, err := loadConfig(cmdArgs.configFile)
 	if err != nil {
 		slog.Error("Failed to get config", "error", err)
 		os.Exit(1)
 	}
 
 	// Handle delete-dst flag if provided
+	if cmdArgs.deleteDst != "" && cmdArgs.dryRun {
+		if err := handleDeleteDestinationPlan(ctx, cfg, cmdArgs.deleteDst, cmdArgs.output); err != nil {
+			slog.Error("Failed to plan deletion of events from destination", "error", err, "calendar", cmdArgs.deleteDst)
+			os.Exit(1)
+		}
+		return
+	}
 	if cmdArgs.deleteDst != "" {
-		if err := handleDeleteDestination(ctx, cfg, cmdArgs.deleteDst); err != nil {
+		if err := handleDeleteDestination(ctx, cfg, cmdArgs.deleteDst, cmdArgs.wait); err != nil {
 			slog.Error("Failed to delete events from destination", "error", err, "calendar", cmdArgs.deleteDst)
+			if errors.Is(err, lock.ErrLocked) {
+				os.Exit(exitLocked)
+			}
 			os.Exit(1)
 		}
 		slog.Info("Successfully deleted all calsync-managed events", "calendar", cmdArgs.deleteDst)
@@ -43,7 +65,7 @@ func run(cmdArgs cmdArgs) {
 	versionChecker := versioncheck.New(Version)
 	go versionChecker.CheckForUpdate(versionChan)
 
-	syncCalendars(ctx, cfg)
+	code := syncCalendars(ctx, cfg, cmdArgs)
 
 	// Check for update info at the very end
 	select {
@@ -57,6 +79,10 @@ func run(cmdArgs cmdArgs) {
 	default:
 		slog.Debug("No update info received, this version is up-to-date")
 	}
+
+	if code != 0 {
+		os.Exit(code)
+	}
 }
 
 func setupLogging() {
@@ -77,12 +103,18 @@ func setupLogging() {
 	slog.SetDefault(logger)
 }
 
-func handleDeleteDestination(ctx context.Context, cfg *config.Config, calendarName string) error {
+func handleDeleteDestination(ctx context.Context, cfg *config.Config, calendarName string, wait time.Duration) error {
 	cal, err := getCalendarByName(ctx, cfg, strings.ToLower(calendarName))
 	if err != nil {
 		return fmt.Errorf("failed to get calendar %s: %w", calendarName, err)
 	}
 
+	release, err := lockTargets(cfg, []calendar.Calendar{cal}, wait)
+	defer release()
+	if err != nil {
+		return fmt.Errorf("another calsync run is syncing %s, rerun later or pass --wait: %w", calendarName, err)
+	}
+
 	slog.Info("Deleting all calsync-managed events",
 		"calendar", calendarName,
 		"nDays", cfg.Sync.Days)
@@ -93,3 +125,38 @@ func handleDeleteDestination(ctx context.Context, cfg *config.Config, calendarNa
 
 	return nil
 }
+
+func handleDeleteDestinationPlan(ctx context.Context, cfg *config.Config, calendarName string, format string) error {
+	cal, err := getCalendarByName(ctx, cfg, strings.ToLower(calendarName))
+	if err != nil {
+		return fmt.Errorf("failed to get calendar %s: %w", calendarName, err)
+	}
+	setDryRun(cal)
+
+	plan, err := cal.PlanDeleteAll(cfg.Sync.Days)
+	if err != nil {
+		return fmt.Errorf("failed to plan deletion of events: %w", err)
+	}
+
+	return printPlans(os.Stdout, []calendar.Plan{plan}, format)
+}
+
+// loadConfig reads the config file given by --config, or the default one
+func loadConfig(location string) (*config.Config, error) {
+	if location == "" {
+		location = config.DefaultConfigFile()
+	}
+
+	cfg, problems, err := config.Load(location)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Using config file", "location", cfg.ConfigFile())
+
+	// Problems are surfaced early, run 'calsync config validate' for details
+	for _, problem := range problems {
+		slog.Warn("Config problem", "problem", problem.String())
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"calsync/config"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the calsync config file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file for unknown keys, missing and invalid values",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		location, _ := cmd.Flags().GetString("config")
+		if location == "" {
+			location = config.DefaultConfigFile()
+		}
+
+		problems, err := config.Validate(location)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "%d problem(s) found in %s\n", len(problems), location)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s: OK\n", location)
+	},
+}
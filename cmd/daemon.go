@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"calsync/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keep running and sync on the schedule configured under [Daemon]",
+	Long: `Syncs right away, then every Daemon.Interval or on the Daemon.Cron schedule.
+Runs never overlap, a run that's due while the previous one is still going is skipped.
+
+SIGINT and SIGTERM stop the daemon once the running sync finished, a second signal
+stops it right away. SIGHUP reloads the config file.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configFile, _ := cmd.Flags().GetString("config")
+		force, _ := cmd.Flags().GetBool("force")
+		wait, _ := cmd.Flags().GetDuration("wait")
+		runDaemon(cmdArgs{configFile: configFile, force: force, wait: wait})
+	},
+}
+
+// schedule returns when the run following t is due, cron.Schedule is one
+type schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// intervalSchedule runs every interval
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+func newSchedule(daemon config.Daemon) (schedule, error) {
+	interval, expr := daemon.Schedule()
+	if expr == "" {
+		return intervalSchedule(interval), nil
+	}
+
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Daemon.Cron %q: %w", expr, err)
+	}
+	return sched, nil
+}
+
+// nextRun returns how long to wait for the next run, with a random jitter added
+func nextRun(sched schedule, jitter time.Duration, now time.Time) time.Duration {
+	wait := sched.Next(now).Sub(now)
+	if jitter > 0 {
+		wait += rand.N(jitter)
+	}
+	return wait
+}
+
+func runDaemon(cmdArgs cmdArgs) {
+	setupLogging()
+
+	slog.Info("Running calsync daemon", "version", fmt.Sprintf("%s-%s-%s", Version, Commit, Date))
+
+	cfg, err := loadConfig(cmdArgs.configFile)
+	if err != nil {
+		slog.Error("Failed to get config", "error", err)
+		os.Exit(exitFailure)
+	}
+	sched, err := newSchedule(cfg.Daemon)
+	if err != nil {
+		slog.Error("Failed to schedule syncs", "error", err)
+		os.Exit(exitFailure)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	d := &daemon{
+		cfg:   cfg,
+		sched: sched,
+		sync: func(cfg *config.Config) int {
+			return syncCalendars(context.Background(), cfg, cmdArgs)
+		},
+		reload: func(cfg *config.Config, sched schedule) (*config.Config, schedule) {
+			return reloadConfig(cmdArgs.configFile, cfg, sched)
+		},
+	}
+	if code := d.run(signals); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// daemon syncs on a schedule, runs never overlap
+type daemon struct {
+	cfg   *config.Config
+	sched schedule
+
+	// sync runs a sync with cfg and returns its exit code
+	sync func(cfg *config.Config) int
+	// reload returns the config and schedule to use from a SIGHUP on
+	reload func(cfg *config.Config, sched schedule) (*config.Config, schedule)
+}
+
+// run syncs right away and then on schedule, until SIGINT or SIGTERM is received from signals.
+// It returns exitFailure when a second signal stopped it during a running sync.
+func (d *daemon) run(signals <-chan os.Signal) int {
+	// done receives the exit code of the running sync, running guards against overlapping runs
+	done := make(chan int, 1)
+	running := false
+
+	// The first sync runs right away
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			wait := nextRun(d.sched, d.cfg.Daemon.Jitter, time.Now())
+			timer.Reset(wait)
+
+			if running {
+				slog.Warn("Previous sync is still running, skipping this one", "next", time.Now().Add(wait).Format(time.RFC3339))
+				continue
+			}
+
+			running = true
+			go func(cfg *config.Config) {
+				done <- d.sync(cfg)
+			}(d.cfg)
+
+		case code := <-done:
+			running = false
+			slog.Info("Finished scheduled sync", "exit_code", code)
+
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				d.cfg, d.sched = d.reload(d.cfg, d.sched)
+				timer.Reset(nextRun(d.sched, d.cfg.Daemon.Jitter, time.Now()))
+				continue
+			}
+
+			slog.Info("Stopping calsync daemon", "signal", sig.String())
+			if running {
+				slog.Info("Waiting for the running sync to finish, signal again to stop right away")
+				select {
+				case <-done:
+				case <-signals:
+					slog.Warn("Stopped during a running sync")
+					return exitFailure
+				}
+			}
+			return 0
+		}
+	}
+}
+
+// reloadConfig reads the config file again, the current config and schedule are kept
+// when it's invalid. A running sync finishes with the config it started with.
+func reloadConfig(location string, cfg *config.Config, sched schedule) (*config.Config, schedule) {
+	slog.Info("Reloading config file")
+
+	newCfg, err := loadConfig(location)
+	if err != nil {
+		slog.Error("Failed to reload config, keeping the current one", "error", err)
+		return cfg, sched
+	}
+	newSched, err := newSchedule(newCfg.Daemon)
+	if err != nil {
+		slog.Error("Failed to reload config, keeping the current one", "error", err)
+		return cfg, sched
+	}
+
+	return newCfg, newSched
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"calsync/config"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSchedule(t *testing.T) {
+	now := time.Date(2026, 5, 4, 10, 7, 30, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		daemon  config.Daemon
+		want    time.Time
+		wantErr string
+	}{
+		{name: "default interval", want: now.Add(15 * time.Minute)},
+		{name: "interval", daemon: config.Daemon{Interval: 5 * time.Minute}, want: now.Add(5 * time.Minute)},
+		{name: "cron", daemon: config.Daemon{Cron: "*/15 8-18 * * *"}, want: time.Date(2026, 5, 4, 10, 15, 0, 0, time.Local)},
+		{name: "cron outside hours", daemon: config.Daemon{Cron: "*/15 8-9 * * *"}, want: time.Date(2026, 5, 5, 8, 0, 0, 0, time.Local)},
+		{name: "cron wins over interval", daemon: config.Daemon{Interval: time.Minute, Cron: "0 * * * *"}, want: time.Date(2026, 5, 4, 11, 0, 0, 0, time.Local)},
+		{name: "invalid cron", daemon: config.Daemon{Cron: "every day"}, wantErr: `parsing Daemon.Cron "every day"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, err := newSchedule(tt.daemon)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, sched.Next(now))
+		})
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Now()
+	sched := intervalSchedule(5 * time.Minute)
+
+	assert.Equal(t, 5*time.Minute, nextRun(sched, 0, now), "Without jitter the run is due on schedule")
+
+	jittered := false
+	for i := 0; i < 100; i++ {
+		wait := nextRun(sched, time.Minute, now)
+		assert.GreaterOrEqual(t, wait, 5*time.Minute)
+		assert.Less(t, wait, 6*time.Minute)
+		jittered = jittered || wait != 5*time.Minute
+	}
+	assert.True(t, jittered, "Runs must be delayed by a random jitter")
+}
+
+// runTestDaemon runs d until it returns, its exit code is sent on the returned channel
+func runTestDaemon(d *daemon, signals <-chan os.Signal) <-chan int {
+	code := make(chan int, 1)
+	go func() {
+		code <- d.run(signals)
+	}()
+	return code
+}
+
+func TestDaemonSkipsOverlappingRuns(t *testing.T) {
+	var calls, active atomic.Int32
+	var overlapped atomic.Bool
+	release := make(chan struct{})
+
+	d := &daemon{
+		cfg:   &config.Config{},
+		sched: intervalSchedule(10 * time.Millisecond),
+		sync: func(*config.Config) int {
+			if active.Add(1) > 1 {
+				overlapped.Store(true)
+			}
+			defer active.Add(-1)
+			calls.Add(1)
+			<-release
+			return 0
+		},
+	}
+	signals := make(chan os.Signal)
+	code := runTestDaemon(d, signals)
+
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, 5*time.Second, time.Millisecond)
+	// Several runs are due while the first one is blocked
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), calls.Load(), "Runs due while a sync is running must be skipped")
+
+	close(release)
+	require.Eventually(t, func() bool { return calls.Load() > 2 }, 5*time.Second, time.Millisecond, "Runs must resume once the sync finished")
+
+	signals <- syscall.SIGTERM
+	assert.Equal(t, 0, <-code)
+	assert.False(t, overlapped.Load(), "Syncs must never overlap")
+}
+
+func TestDaemonStop(t *testing.T) {
+	tests := []struct {
+		name string
+		// interrupt sends a second signal while the sync is running
+		interrupt bool
+		want      int
+	}{
+		{name: "waits for the running sync", want: 0},
+		{name: "second signal", interrupt: true, want: exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			defer close(release)
+
+			d := &daemon{
+				cfg:   &config.Config{},
+				sched: intervalSchedule(time.Hour),
+				sync: func(*config.Config) int {
+					close(started)
+					<-release
+					return exitPartial
+				},
+			}
+			signals := make(chan os.Signal)
+			code := runTestDaemon(d, signals)
+
+			<-started
+			signals <- syscall.SIGTERM
+			if tt.interrupt {
+				signals <- syscall.SIGINT
+			} else {
+				select {
+				case <-code:
+					t.Fatal("The daemon must wait for the running sync to finish")
+				case <-time.After(50 * time.Millisecond):
+				}
+				release <- struct{}{}
+			}
+			assert.Equal(t, tt.want, <-code)
+		})
+	}
+}
+
+func TestDaemonReload(t *testing.T) {
+	initial := &config.Config{}
+	reloaded := &config.Config{}
+	synced := make(chan *config.Config)
+
+	d := &daemon{
+		cfg:   initial,
+		sched: intervalSchedule(time.Hour),
+		sync: func(cfg *config.Config) int {
+			// Runs after the ones looked at aren't waited for
+			select {
+			case synced <- cfg:
+			case <-time.After(time.Second):
+			}
+			return 0
+		},
+		reload: func(*config.Config, schedule) (*config.Config, schedule) {
+			return reloaded, intervalSchedule(10 * time.Millisecond)
+		},
+	}
+	signals := make(chan os.Signal)
+	code := runTestDaemon(d, signals)
+
+	assert.Same(t, initial, <-synced)
+	signals <- syscall.SIGHUP
+	assert.Same(t, reloaded, <-synced, "Syncs after a reload must use the reloaded config and schedule")
+
+	signals <- syscall.SIGTERM
+	assert.Equal(t, 0, <-code)
+}
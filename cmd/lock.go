@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"calsync/calendar"
+	"calsync/config"
+	"calsync/lock"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+)
+
+// lockPollInterval is how often a held lock is checked again with --wait
+const lockPollInterval = time.Second
+
+// lockFile returns the lock file of a target calendar, one per config file and target
+func lockFile(cfg *config.Config, target calendar.Calendar) string {
+	sum := sha256.Sum256([]byte(cfg.ConfigFile() + "\x00" + target.String()))
+	return filepath.Join(config.DefaultStateDir(), "locks", hex.EncodeToString(sum[:8])+".lock")
+}
+
+// lockTargets locks every target, waiting up to wait for other runs to release them.
+// The returned func releases the locks acquired so far, also when an error is returned.
+func lockTargets(cfg *config.Config, targets []calendar.Calendar, wait time.Duration) (func(), error) {
+	locks := make([]*lock.Lock, 0, len(targets))
+	release := func() {
+		for _, l := range locks {
+			if err := l.Release(); err != nil {
+				slog.Warn("Failed to release lock", "error", err)
+			}
+		}
+	}
+
+	for _, target := range targets {
+		path := lockFile(cfg, target)
+
+		l, err := lock.Acquire(path)
+		if errors.Is(err, lock.ErrLocked) && wait > 0 {
+			slog.Info("Waiting for another calsync run to finish syncing the target calendar", "target", target, "wait", wait)
+
+			ctx, cancel := context.WithTimeout(context.Background(), wait)
+			l, err = lock.Wait(ctx, path, lockPollInterval)
+			cancel()
+		}
+		if err != nil {
+			return release, fmt.Errorf("%s: %w", target, err)
+		}
+
+		locks = append(locks, l)
+	}
+
+	return release, nil
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"calsync/calendar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// printPlans writes the plans computed by --dry-run, as a table or as JSON
+func printPlans(w io.Writer, plans []calendar.Plan, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plans)
+	case "table", "":
+		return printPlansTable(w, plans)
+	default:
+		return fmt.Errorf("unknown output format %q, expected 'table' or 'json'", format)
+	}
+}
+
+func printPlansTable(w io.Writer, plans []calendar.Plan) error {
+	for _, plan := range plans {
+		fmt.Fprintf(w, "%s: %d to create, %d to update, %d to delete, %d to skip, %d to ignore, %d to preserve\n",
+			plan.Calendar,
+			plan.Count(calendar.ActionCreate),
+			plan.Count(calendar.ActionUpdate),
+			plan.Count(calendar.ActionDelete),
+			plan.Count(calendar.ActionSkip),
+			plan.Count(calendar.ActionIgnore),
+			plan.Count(calendar.ActionPreserve))
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ACTION\tSTART\tEND\tTITLE\tREASON")
+		for _, change := range plan.Changes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", change.Action, change.Start, change.End, change.Title, change.Reason)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bytes"
+	"calsync/calendar"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPlans() []calendar.Plan {
+	return []calendar.Plan{{
+		Calendar: "Google Calendar: team",
+		Changes: []calendar.Change{
+			{Action: calendar.ActionCreate, Title: "Standup", Start: "2026-05-04T09:00:00Z", End: "2026-05-04T09:15:00Z", Reason: "new at source"},
+			{Action: calendar.ActionUpdate, Title: "Design review", Start: "2026-05-04T14:00:00Z", End: "2026-05-04T15:00:00Z", ID: "event2", Reason: "changed at source"},
+			{Action: calendar.ActionDelete, Title: "Offsite", Start: "2026-05-06", End: "2026-05-08", ID: "event3", Reason: "not at source anymore"},
+			{Action: calendar.ActionSkip, Title: "Dentist", Start: "2026-05-05T08:30:00Z", End: "2026-05-05T09:30:00Z", ID: "event4", Reason: "already synced"},
+		},
+	}}
+}
+
+func TestPrintPlansTable(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, printPlans(&out, testPlans(), "table"))
+
+	want := `Google Calendar: team: 1 to create, 1 to update, 1 to delete, 1 to skip, 0 to ignore, 0 to preserve
+ACTION  START                 END                   TITLE          REASON
+create  2026-05-04T09:00:00Z  2026-05-04T09:15:00Z  Standup        new at source
+update  2026-05-04T14:00:00Z  2026-05-04T15:00:00Z  Design review  changed at source
+delete  2026-05-06            2026-05-08            Offsite        not at source anymore
+skip    2026-05-05T08:30:00Z  2026-05-05T09:30:00Z  Dentist        already synced
+
+`
+	assert.Equal(t, want, out.String())
+
+	var byDefault bytes.Buffer
+	require.NoError(t, printPlans(&byDefault, testPlans(), ""))
+	assert.Equal(t, want, byDefault.String(), "The table is the default format")
+}
+
+func TestPrintPlansJSON(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, printPlans(&out, testPlans(), "json"))
+
+	var got []calendar.Plan
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, testPlans(), got)
+	assert.Contains(t, out.String(), `"action": "delete"`)
+}
+
+func TestPrintPlansUnknownFormat(t *testing.T) {
+	var out bytes.Buffer
+	assert.EqualError(t, printPlans(&out, testPlans(), "yaml"), `unknown output format "yaml", expected 'table' or 'json'`)
+	assert.Empty(t, out.String())
+}
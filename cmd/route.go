@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"calsync/calendar"
+	"calsync/config"
+	"fmt"
+	"log/slog"
+)
+
+// routeTable knows which source calendars feed which target calendars
+type routeTable struct {
+	allSources []configuredCalendar
+	allTargets []configuredCalendar
+
+	// sourcesOf maps a target, by position in allTargets, to its sources' String()
+	sourcesOf map[int]map[string]bool
+}
+
+// newRouteTable resolves the configured routes, without routes every source goes to every target
+func newRouteTable(routes []config.Route, sources []configuredCalendar, targets []configuredCalendar) (*routeTable, error) {
+	rt := &routeTable{
+		allSources: sources,
+		allTargets: targets,
+		sourcesOf:  make(map[int]map[string]bool),
+	}
+
+	if len(routes) == 0 {
+		for i := range targets {
+			rt.sourcesOf[i] = make(map[string]bool)
+			for _, src := range sources {
+				rt.sourcesOf[i][src.String()] = true
+			}
+		}
+		return rt, nil
+	}
+
+	for n, route := range routes {
+		routeSources, err := matchCalendars(route.Sources, sources)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: sources: %w", n+1, err)
+		}
+		routeTargets, err := matchCalendars(route.Targets, targets)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: targets: %w", n+1, err)
+		}
+
+		for _, i := range routeTargets {
+			if rt.sourcesOf[i] == nil {
+				rt.sourcesOf[i] = make(map[string]bool)
+			}
+			for _, j := range routeSources {
+				rt.sourcesOf[i][sources[j].String()] = true
+			}
+		}
+	}
+
+	for i, target := range targets {
+		if len(rt.sourcesOf[i]) == 0 {
+			slog.Warn("No sources routed to target calendar, it won't be synced", "target", target)
+		}
+	}
+
+	return rt, nil
+}
+
+// matchCalendars returns positions of calendars matching any of the names, each name must match
+func matchCalendars(names []string, calendars []configuredCalendar) ([]int, error) {
+	matched := make([]int, 0)
+	for _, name := range names {
+		found := false
+		for i, cal := range calendars {
+			if cal.matches(name) {
+				matched = append(matched, i)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no enabled calendar named %q", name)
+		}
+	}
+
+	return matched, nil
+}
+
+// sources returns the source calendars routed to at least one target
+func (rt *routeTable) sources() []calendar.Calendar {
+	routed := make([]calendar.Calendar, 0, len(rt.allSources))
+	for _, src := range rt.allSources {
+		for _, srcs := range rt.sourcesOf {
+			if srcs[src.String()] {
+				routed = append(routed, src.Calendar)
+				break
+			}
+		}
+	}
+
+	return routed
+}
+
+// targets returns the target calendars with at least one source routed to them
+func (rt *routeTable) targets() []calendar.Calendar {
+	routed := make([]calendar.Calendar, 0, len(rt.allTargets))
+	for i, target := range rt.allTargets {
+		if len(rt.sourcesOf[i]) > 0 {
+			routed = append(routed, target.Calendar)
+		}
+	}
+
+	return routed
+}
+
+// sourcesFor returns the String() of sources routed to the target
+func (rt *routeTable) sourcesFor(target calendar.Calendar) map[string]bool {
+	for i, t := range rt.allTargets {
+		if t.Calendar == target {
+			return rt.sourcesOf[i]
+		}
+	}
+	return nil
+}
+
+// eventsFor returns the events, from all sources, that are routed to the target
+func (rt *routeTable) eventsFor(target calendar.Calendar, events []calendar.Event) []calendar.Event {
+	srcs := rt.sourcesFor(target)
+
+	routed := make([]calendar.Event, 0, len(events))
+	for _, event := range events {
+		if srcs[event.Calendar] {
+			routed = append(routed, event)
+		}
+	}
+
+	return routed
+}
+
+// failedSourcesFor returns the failed sources that are routed to the target
+func (rt *routeTable) failedSourcesFor(target calendar.Calendar, failed []string) []string {
+	srcs := rt.sourcesFor(target)
+
+	routed := make([]string, 0, len(failed))
+	for _, src := range failed {
+		if srcs[src] {
+			routed = append(routed, src)
+		}
+	}
+
+	return routed
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"calsync/calendar"
+	"calsync/config"
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCalendar is a source returning events, or err, after delay. Other methods aren't implemented.
+type fakeCalendar struct {
+	calendar.Calendar
+
+	name   string
+	events []calendar.Event
+	err    error
+	delay  time.Duration
+}
+
+func (f *fakeCalendar) String() string {
+	return f.name
+}
+
+func (f *fakeCalendar) GetEvents(ctx context.Context, start time.Time, end time.Time) ([]calendar.Event, error) {
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]calendar.Event(nil), f.events...), nil
+}
+
+func newConfigured(typ string, name string) configuredCalendar {
+	return configuredCalendar{Calendar: &fakeCalendar{name: typ + ": " + name}, typ: typ, name: name}
+}
+
+func names(calendars []calendar.Calendar) []string {
+	got := make([]string, 0, len(calendars))
+	for _, cal := range calendars {
+		got = append(got, cal.String())
+	}
+	return got
+}
+
+func TestRouteTable(t *testing.T) {
+	sources := []configuredCalendar{newConfigured("ical", "oncall"), newConfigured("ical", "offsites"), newConfigured("mac", "")}
+	targets := []configuredCalendar{newConfigured("google", "team"), newConfigured("google", "personal"), newConfigured("icsfile", "khal")}
+
+	tests := []struct {
+		name        string
+		routes      []config.Route
+		wantSources []string
+		// wantRouted maps routed targets to their sources
+		wantRouted map[string][]string
+		wantErr    string
+	}{
+		{
+			name:        "without routes every source goes to every target",
+			wantSources: []string{"ical: oncall", "ical: offsites", "mac: "},
+			wantRouted: map[string][]string{
+				"google: team":     {"ical: offsites", "ical: oncall", "mac: "},
+				"google: personal": {"ical: offsites", "ical: oncall", "mac: "},
+				"icsfile: khal":    {"ical: offsites", "ical: oncall", "mac: "},
+			},
+		},
+		{
+			name:        "by name",
+			routes:      []config.Route{{Sources: []string{"oncall"}, Targets: []string{"Team"}}},
+			wantSources: []string{"ical: oncall"},
+			wantRouted:  map[string][]string{"google: team": {"ical: oncall"}},
+		},
+		{
+			name: "by type",
+			routes: []config.Route{
+				{Sources: []string{"ICal"}, Targets: []string{"google"}},
+				{Sources: []string{"mac"}, Targets: []string{"khal", "personal"}},
+			},
+			wantSources: []string{"ical: oncall", "ical: offsites", "mac: "},
+			wantRouted: map[string][]string{
+				"google: team":     {"ical: offsites", "ical: oncall"},
+				"google: personal": {"ical: offsites", "ical: oncall", "mac: "},
+				"icsfile: khal":    {"mac: "},
+			},
+		},
+		{
+			name:    "unknown source",
+			routes:  []config.Route{{Sources: []string{"oncall", "holidays"}, Targets: []string{"team"}}},
+			wantErr: `route 1: sources: no enabled calendar named "holidays"`,
+		},
+		{
+			name:    "unknown target",
+			routes:  []config.Route{{Sources: []string{"oncall"}, Targets: []string{"team"}}, {Sources: []string{"mac"}, Targets: []string{"caldav"}}},
+			wantErr: `route 2: targets: no enabled calendar named "caldav"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := newRouteTable(tt.routes, sources, targets)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantSources, names(rt.sources()))
+
+			routed := make(map[string][]string)
+			for _, target := range rt.targets() {
+				srcs := make([]string, 0)
+				for src := range rt.sourcesFor(target) {
+					srcs = append(srcs, src)
+				}
+				sort.Strings(srcs)
+				routed[target.String()] = srcs
+			}
+			assert.Equal(t, tt.wantRouted, routed)
+		})
+	}
+}
+
+func TestRouteTableEvents(t *testing.T) {
+	sources := []configuredCalendar{newConfigured("ical", "oncall"), newConfigured("ical", "offsites")}
+	targets := []configuredCalendar{newConfigured("google", "team"), newConfigured("google", "personal")}
+	rt, err := newRouteTable([]config.Route{
+		{Sources: []string{"oncall"}, Targets: []string{"team"}},
+		{Sources: []string{"ical"}, Targets: []string{"personal"}},
+	}, sources, targets)
+	require.NoError(t, err)
+
+	events := []calendar.Event{
+		{Title: "Pager", Calendar: "ical: oncall"},
+		{Title: "Offsite", Calendar: "ical: offsites"},
+	}
+	team, personal := targets[0].Calendar, targets[1].Calendar
+
+	assert.Equal(t, events[:1], rt.eventsFor(team, events))
+	assert.Equal(t, events, rt.eventsFor(personal, events))
+
+	failed := []string{"ical: offsites"}
+	assert.Empty(t, rt.failedSourcesFor(team, failed), "A failed source that isn't routed to the target doesn't concern it")
+	assert.Equal(t, failed, rt.failedSourcesFor(personal, failed))
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"calsync/calendar"
+	"calsync/config"
+	"calsync/feed"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// serveShutdownTimeout is how long requests being served get to finish when stopping
+const serveShutdownTimeout = 5 * time.Second
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the events of the source calendars as an ICS feed over HTTP",
+	Long: `Gets the events of the sources configured under [Serve] right away, then every
+Serve.Interval, and serves them as an ICS feed calendar apps can subscribe to. The URL
+of the feed has a secret token, anyone with the URL can read the feed. It's never logged,
+--print-url prints it.
+
+Events of a source that failed are kept from its last successful refresh.
+SIGINT and SIGTERM stop serving.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configFile, _ := cmd.Flags().GetString("config")
+		printURL, _ := cmd.Flags().GetBool("print-url")
+		runServe(cmdArgs{configFile: configFile, printURL: printURL})
+	},
+}
+
+func runServe(cmdArgs cmdArgs) {
+	setupLogging()
+
+	slog.Info("Running calsync serve", "version", fmt.Sprintf("%s-%s-%s", Version, Commit, Date))
+
+	cfg, err := loadConfig(cmdArgs.configFile)
+	if err != nil {
+		slog.Error("Failed to get config", "error", err)
+		os.Exit(exitFailure)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	sources, err := getServeSources(ctx, cfg)
+	if err != nil {
+		slog.Error("Failed to get source calendars", "error", err)
+		os.Exit(exitFailure)
+	}
+
+	token, err := serveToken(cfg.Serve)
+	if err != nil {
+		slog.Error("Failed to get the token of the feed", "error", err)
+		os.Exit(exitFailure)
+	}
+	f := feed.New(token, cfg.Serve.BusyOnly)
+
+	listener, err := net.Listen("tcp", cfg.Serve.Address())
+	if err != nil {
+		slog.Error("Failed to listen", "error", err)
+		os.Exit(exitFailure)
+	}
+	server := &http.Server{Handler: f, ReadHeaderTimeout: 10 * time.Second}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	// The URL holds the token, it mustn't end up in logs
+	slog.Info("Serving ICS feed", "address", listener.Addr().String(), "busy_only", cfg.Serve.BusyOnly)
+	if cmdArgs.printURL {
+		fmt.Println("http://" + listener.Addr().String() + f.Path())
+	}
+
+	// The first refresh runs right away
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var events []calendar.Event
+	for {
+		select {
+		case <-timer.C:
+			events = refreshFeed(ctx, cfg, f, sources, events)
+			timer.Reset(cfg.Serve.RefreshInterval())
+
+		case err := <-served:
+			slog.Error("Stopped serving", "error", err)
+			os.Exit(exitFailure)
+
+		case <-ctx.Done():
+			slog.Info("Stopping calsync serve")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				slog.Warn("Failed to stop serving gracefully", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// getServeSources returns the enabled sources named in Serve.Sources, all of them when empty
+func getServeSources(ctx context.Context, cfg *config.Config) ([]calendar.Calendar, error) {
+	configured, err := getCalendarsFor(ctx, cfg, cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+	if len(configured) == 0 {
+		return nil, fmt.Errorf("no enabled source calendars found")
+	}
+
+	sources := make([]calendar.Calendar, 0, len(configured))
+	if len(cfg.Serve.Sources) == 0 {
+		for _, src := range configured {
+			sources = append(sources, src.Calendar)
+		}
+	} else {
+		matched, err := matchCalendars(cfg.Serve.Sources, configured)
+		if err != nil {
+			return nil, fmt.Errorf("Serve.Sources: %w", err)
+		}
+		seen := make(map[int]bool)
+		for _, i := range matched {
+			if !seen[i] {
+				seen[i] = true
+				sources = append(sources, configured[i].Calendar)
+			}
+		}
+	}
+
+	slog.Info("Configured calendars", "sources", sources)
+	return sources, nil
+}
+
+// refreshFeed updates the feed with the events of the sources, the events of failed sources
+// are kept from previous. The events the feed was updated with are returned.
+func refreshFeed(ctx context.Context, cfg *config.Config, f *feed.Feed, sources []calendar.Calendar, previous []calendar.Event) []calendar.Event {
+	start, end := syncRange(cfg.Sync)
+
+	events, failedSources, err := getSourceEventsSorted(ctx, sources, start, end, cfg.Sync.Timeout())
+	if err != nil {
+		if len(failedSources) == len(sources) {
+			slog.Error("Failed to get events from all source calendars, the feed is unchanged", "error", err)
+			return previous
+		}
+		slog.Warn("Keeping events of failed source calendars from their last refresh", "failed", failedSources)
+		events = append(events, eventsOf(previous, failedSources)...)
+	}
+
+	if err := f.Update(events, time.Now()); err != nil {
+		slog.Error("Failed to render the feed, it's unchanged", "error", err)
+		return previous
+	}
+	slog.Info("Refreshed ICS feed", "events", len(events), "next", time.Now().Add(cfg.Serve.RefreshInterval()).Format(time.RFC3339))
+
+	return events
+}
+
+// eventsOf returns the events that came from one of sources
+func eventsOf(events []calendar.Event, sources []string) []calendar.Event {
+	wanted := make(map[string]bool, len(sources))
+	for _, src := range sources {
+		wanted[src] = true
+	}
+
+	kept := make([]calendar.Event, 0)
+	for _, event := range events {
+		if wanted[event.Calendar] {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
+
+// serveToken returns Serve.Token, or the generated token kept in the state directory.
+// A token is generated on first use.
+func serveToken(serve config.Serve) (string, error) {
+	if serve.Token != "" {
+		return serve.Token, nil
+	}
+
+	path := serve.TokenFile()
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(b)); token != "" {
+			return token, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	slog.Info("Generated the token of the feed", "file", path)
+
+	return token, nil
+}
@@ -2,10 +2,13 @@ package config
 
 import (
 	"calsync/calendar"
+	"errors"
 	"fmt"
-	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -16,13 +19,52 @@ type Config struct {
 	Source Calendars
 	Target Calendars
 
+	// Routes maps sources to targets, every source goes to every target when empty
+	Routes []Route `toml:"Route"`
+
 	Sync Sync
+
+	Daemon Daemon
+
+	Serve Serve
+
+	// location is the config file this config was read from
+	location string
 }
 
+// Calendars holds all calendars of each type, configured either as a single
+// table ([Source.ICal]) or as a list of named tables ([[Source.ICal]]).
 type Calendars struct {
-	Mac    *Mac
-	ICal   *ICal
-	Google *Google
+	Mac     []*Mac
+	ICal    []*ICal
+	Google  []*Google
+	CalDAV  []*CalDAV
+	ICSFile []*ICSFile
+}
+
+// rawConfig is Config before the calendars are decoded, as each type
+// can either be a table or an array of tables.
+type rawConfig struct {
+	Version string
+
+	Source rawCalendars
+	Target rawCalendars
+
+	Routes []Route `toml:"Route"`
+
+	Sync Sync
+
+	Daemon Daemon
+
+	Serve Serve
+}
+
+type rawCalendars struct {
+	Mac     toml.Primitive
+	ICal    toml.Primitive
+	Google  toml.Primitive
+	CalDAV  toml.Primitive
+	ICSFile toml.Primitive
 }
 type SrcCalBase struct {
 	Enabled bool
@@ -38,56 +80,405 @@ type Mac struct {
 type ICal struct {
 	SrcCalBase
 
+	// Name identifies the feed in logs, the URL is used when empty
+	Name string
+	// URL of the feed: http(s):// or webcal://, or file:// or the path of an .ics file or
+	// of a directory of them
 	URL string
+
+	// configDir is where a relative path is resolved from
+	configDir string
 }
 
 type Google struct {
 	SrcCalBase
 
+	// Name identifies the calendar in logs and --delete-dst, the Id is used when empty
+	Name        string
 	Id          string
 	Credentials string
 	Token       string
+
+	// ShowAttendees appends organizer and attendees (with their response status)
+	// to the event description. Attendees are never invited.
+	ShowAttendees bool
+
+	// Namespace separates calsync setups syncing into the same calendar, events synced
+	// with another namespace are never updated or deleted. Empty is the default namespace.
+	Namespace string
+	// AdoptUntagged takes over events synced without a namespace (e.g. by older versions)
+	// into Namespace. Only one of the setups sharing the calendar should set it.
+	AdoptUntagged bool
+
+	// configDir is where relative Credentials and Token are resolved from
+	configDir string
+}
+
+// CalDAV is a calendar collection on a CalDAV server, e.g. Fastmail or Nextcloud, as
+// a source or a target
+type CalDAV struct {
+	SrcCalBase
+
+	// Name identifies the calendar in logs and --delete-dst, the URL is used when empty
+	Name string
+	// URL of the calendar collection, or of the account (principal or calendar home)
+	// whose first calendar is used
+	URL      string
+	Username string
+	// PasswordCommand prints the password, e.g. "pass show fastmail/calsync", so that
+	// it's never stored in the config file
+	PasswordCommand string
+}
+
+// ICSFile is a local .ics file the synced events are written to, for other tools to read
+type ICSFile struct {
+	SrcCalBase
+
+	// Name identifies the file in logs and --delete-dst, the Path is used when empty
+	Name string
+	// Path of the file, relative to the config file's directory
+	Path string
+
+	// configDir is where a relative Path is resolved from
+	configDir string
+}
+
+// Route sends events of Sources to Targets. Both are lists of calendar
+// Names, or calendar types (e.g. "ical") to match all calendars of that type.
+type Route struct {
+	Sources []string
+	Targets []string
 }
 
 type Sync struct {
 	Days int
+
+	// MaxDeletes aborts a sync that would delete more events, 0 means no limit
+	MaxDeletes int
+	// MaxDeletePercent aborts a sync that would delete a larger share of
+	// calsync-managed events, defaults to 50, 100 means no limit
+	MaxDeletePercent int
+	// MinDeletesForPercent is how many deletes MaxDeletePercent allows regardless of the share,
+	// so that removing a few events from a small calendar isn't aborted, defaults to 5
+	MinDeletesForPercent int
+
+	// RequestsPerSecond limits calls to the Google API, defaults to 5
+	RequestsPerSecond float64
+	// MaxRetries is how often a rate limited or failed call is retried, defaults to 5
+	MaxRetries int
+
+	// SourceTimeout limits how long fetching a single source may take, e.g. "30s", defaults to 1m
+	SourceTimeout time.Duration
 }
 
+// Daemon configures when 'calsync daemon' syncs, either every Interval or on a Cron schedule
+type Daemon struct {
+	// Interval between syncs, e.g. "15m", defaults to 15m when Cron isn't set either
+	Interval time.Duration
+	// Cron is a standard 5-field cron expression, e.g. "*/15 8-18 * * 1-5"
+	Cron string
+	// Jitter delays each sync by a random duration up to Jitter, e.g. "1m"
+	Jitter time.Duration
+}
+
+const defaultDaemonInterval = 15 * time.Minute
+
+// Schedule returns the interval or the cron expression of the daemon, with defaults applied
+func (d Daemon) Schedule() (interval time.Duration, cron string) {
+	if d.Cron != "" {
+		return 0, d.Cron
+	}
+	if d.Interval == 0 {
+		return defaultDaemonInterval, ""
+	}
+	return d.Interval, ""
+}
+
+// Serve configures 'calsync serve', which publishes the events of the sources as an ICS feed
+type Serve struct {
+	// Listen is the address the feed is served on, defaults to localhost:8080
+	Listen string
+	// Token is the secret part of the feed's URL. When empty, one is generated and kept
+	// in the state directory.
+	Token string
+	// Interval between refreshes of the feed, e.g. "15m", defaults to 15m
+	Interval time.Duration
+	// Sources of the feed, by Name or type, every enabled source when empty
+	Sources []string
+	// BusyOnly publishes the time of events only, titled "Busy"
+	BusyOnly bool
+}
+
+const (
+	defaultServeListen   = "localhost:8080"
+	defaultServeInterval = 15 * time.Minute
+)
+
+// Address returns Listen, with the default applied
+func (s Serve) Address() string {
+	if s.Listen == "" {
+		return defaultServeListen
+	}
+	return s.Listen
+}
+
+// RefreshInterval returns Interval, with the default applied
+func (s Serve) RefreshInterval() time.Duration {
+	if s.Interval == 0 {
+		return defaultServeInterval
+	}
+	return s.Interval
+}
+
+// TokenFile returns where the generated token of the feed is kept
+func (s Serve) TokenFile() string {
+	return filepath.Join(DefaultStateDir(), "serve-token")
+}
+
+const (
+	defaultMaxDeletePercent     = 50
+	defaultMinDeletesForPercent = 5
+	defaultRequestsPerSecond    = 5
+	defaultMaxRetries           = 5
+	defaultSourceTimeout        = time.Minute
+)
+
+// DeleteLimits returns the safety thresholds for deletions, with defaults applied
+func (s Sync) DeleteLimits() (maxDeletes int, maxPercent int, minDeletesForPercent int) {
+	maxPercent = s.MaxDeletePercent
+	if maxPercent == 0 {
+		maxPercent = defaultMaxDeletePercent
+	}
+	minDeletesForPercent = s.MinDeletesForPercent
+	if minDeletesForPercent == 0 {
+		minDeletesForPercent = defaultMinDeletesForPercent
+	}
+	return s.MaxDeletes, maxPercent, minDeletesForPercent
+}
+
+// RateLimits returns the client-side rate limit and retries of API calls, with defaults applied
+func (s Sync) RateLimits() (requestsPerSecond float64, maxRetries int) {
+	requestsPerSecond = s.RequestsPerSecond
+	if requestsPerSecond == 0 {
+		requestsPerSecond = defaultRequestsPerSecond
+	}
+	maxRetries = s.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+	return requestsPerSecond, maxRetries
+}
+
+// Timeout returns SourceTimeout, with the default applied
+func (s Sync) Timeout() time.Duration {
+	if s.SourceTimeout == 0 {
+		return defaultSourceTimeout
+	}
+	return s.SourceTimeout
+}
+
+// TokenFile returns the OAuth token of this calendar, so that each target can use its own account
 func (g Google) TokenFile() string {
+	return resolveFile(g.configDir, g.Token, "token.json")
+}
+
+// CredentialsFile returns the OAuth client secret of this calendar
+func (g Google) CredentialsFile() string {
+	return resolveFile(g.configDir, g.Credentials, "credentials.json")
+}
+
+// SyncStateFile returns where the incremental sync token and the local copy of
+// this calendar are kept, one file per calendar Id
+func (g Google) SyncStateFile() string {
+	return filepath.Join(DefaultStateDir(), "gcal-"+url.PathEscape(g.Id)+".json")
+}
+
+// EventStateFile returns where the Google event each source event was synced to is
+// kept, one file per calendar Id and Namespace
+func (g Google) EventStateFile() string {
+	name := g.Id
+	if g.Namespace != "" {
+		name += "-" + g.Namespace
+	}
+	return filepath.Join(DefaultStateDir(), "gcal-"+url.PathEscape(name)+"-events.json")
+}
+
+// Location returns the URL, a relative path is relative to the config file's directory
+func (i ICal) Location() string {
+	if i.URL == "" || strings.Contains(i.URL, "://") {
+		return i.URL
+	}
+	return resolveFile(i.configDir, i.URL, "")
+}
+
+// File returns the path of the file written
+func (f ICSFile) File() string {
+	return resolveFile(f.configDir, f.Path, "")
+}
+
+// resolveFile returns path relative to dir (the default config directory when empty),
+// or def when path is empty
+func resolveFile(dir string, path string, def string) string {
+	if path == "" {
+		path = def
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	if dir == "" {
+		dir = DefaultConfigDir()
+	}
+	return filepath.Join(dir, path)
+}
+
+// DefaultConfigDir returns $XDG_CONFIG_HOME/calsync, or ~/.config/calsync
+func DefaultConfigDir() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "calsync")
+	}
 	return filepath.Join(
 		os.Getenv("HOME"),
 		"/.config/calsync/",
-		"token.json",
 	)
 }
 
-func (c Config) CredentialsFile() string {
+// DefaultStateDir returns $XDG_STATE_HOME/calsync, or ~/.local/state/calsync
+func DefaultStateDir() string {
+	if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "calsync")
+	}
 	return filepath.Join(
 		os.Getenv("HOME"),
-		"/.config/calsync/",
-		"credentials.json",
+		"/.local/state/calsync/",
 	)
 }
 
+// DefaultConfigFile returns config.toml in the default config directory
+func DefaultConfigFile() string {
+	return filepath.Join(DefaultConfigDir(), "config.toml")
+}
+
 func (c Config) ConfigFile() string {
-	return filepath.Join(
-		os.Getenv("HOME"),
-		"/.config/calsync/",
-		"config.toml",
-	)
+	if c.location != "" {
+		return c.location
+	}
+	return DefaultConfigFile()
 }
 
+var ErrConfigNotFound = errors.New("config file not found")
+
 func GetConfig(location string) (*Config, error) {
-	if _, err := os.Stat(location); os.IsNotExist(err) {
-		slog.Error("Config file not found", "location", location)
-		os.Exit(1)
+	content, err := readConfigFile(location)
+	if err != nil {
+		return &Config{}, err
+	}
+	config, _, err := decode(location, content)
+	return config, err
+}
+
+// Load reads and decodes the config file once, the problems found validating
+// it are returned along with the config
+func Load(location string) (*Config, []Problem, error) {
+	content, err := readConfigFile(location)
+	if err != nil {
+		return &Config{}, nil, err
+	}
+	config, md, err := decode(location, content)
+	if err != nil {
+		return config, nil, err
 	}
+	return config, validate(location, content, config, md), nil
+}
+
+func readConfigFile(location string) ([]byte, error) {
+	content, err := os.ReadFile(location)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	return content, nil
+}
 
-	config := &Config{}
-	_, err := toml.DecodeFile(location, config)
+// decode decodes the content of the config file, MetaData is returned for validation
+func decode(location string, content []byte) (*Config, toml.MetaData, error) {
+	raw := &rawConfig{}
+	md, err := toml.Decode(string(content), raw)
 	if err != nil {
-		return config, fmt.Errorf("Failed to decode config file: %s", err)
+		return &Config{}, md, fmt.Errorf("Failed to decode config file: %w", err)
+	}
+
+	if abs, err := filepath.Abs(location); err == nil {
+		location = abs
+	}
+
+	config := &Config{
+		Version:  raw.Version,
+		Routes:   raw.Routes,
+		Sync:     raw.Sync,
+		Daemon:   raw.Daemon,
+		Serve:    raw.Serve,
+		location: location,
+	}
+
+	if config.Source, err = decodeCalendars(md, raw.Source); err != nil {
+		return config, md, fmt.Errorf("Failed to decode source calendars: %w", err)
+	}
+	if config.Target, err = decodeCalendars(md, raw.Target); err != nil {
+		return config, md, fmt.Errorf("Failed to decode target calendars: %w", err)
+	}
+
+	// Paths of feeds and files, Credentials and Token are relative to the config file
+	for _, cals := range []Calendars{config.Source, config.Target} {
+		for _, i := range cals.ICal {
+			i.configDir = filepath.Dir(location)
+		}
+		for _, g := range cals.Google {
+			g.configDir = filepath.Dir(location)
+		}
+		for _, f := range cals.ICSFile {
+			f.configDir = filepath.Dir(location)
+		}
+	}
+
+	return config, md, nil
+}
+
+func decodeCalendars(md toml.MetaData, raw rawCalendars) (Calendars, error) {
+	var cals Calendars
+	var err error
+
+	if cals.Mac, err = decodeList[Mac](md, raw.Mac); err != nil {
+		return cals, fmt.Errorf("Mac: %w", err)
+	}
+	if cals.ICal, err = decodeList[ICal](md, raw.ICal); err != nil {
+		return cals, fmt.Errorf("ICal: %w", err)
+	}
+	if cals.Google, err = decodeList[Google](md, raw.Google); err != nil {
+		return cals, fmt.Errorf("Google: %w", err)
+	}
+	if cals.CalDAV, err = decodeList[CalDAV](md, raw.CalDAV); err != nil {
+		return cals, fmt.Errorf("CalDAV: %w", err)
+	}
+	if cals.ICSFile, err = decodeList[ICSFile](md, raw.ICSFile); err != nil {
+		return cals, fmt.Errorf("ICSFile: %w", err)
+	}
+
+	return cals, nil
+}
+
+// decodeList decodes either an array of tables or a single table, to a list
+func decodeList[T any](md toml.MetaData, prim toml.Primitive) ([]*T, error) {
+	var list []*T
+	if err := md.PrimitiveDecode(prim, &list); err == nil {
+		return list, nil
+	}
+
+	single := new(T)
+	if err := md.PrimitiveDecode(prim, single); err != nil {
+		return nil, err
 	}
 
-	return config, nil
+	return []*T{single}, nil
 }
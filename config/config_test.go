@@ -3,12 +3,16 @@ package config
 import (
 	"log/slog"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGetConfig(t *testing.T) {
+	testdataDir, _ := filepath.Abs("testdata")
+
 	got, err := GetConfig("testdata/config.toml")
 	if err != nil {
 		slog.Error("Failed to get config", "error", err)
@@ -16,34 +20,154 @@ func TestGetConfig(t *testing.T) {
 	}
 	expected := &Config{
 		Source: Calendars{
-			Mac: &Mac{
-				SrcCalBase: SrcCalBase{
-					Enabled: false,
+			Mac: []*Mac{
+				{
+					SrcCalBase: SrcCalBase{
+						Enabled: false,
+					},
+					ICalBuddyBinary: "/usr/local/bin/icalBuddy",
+					Name:            "Calendar",
 				},
-				ICalBuddyBinary: "/usr/local/bin/icalBuddy",
-				Name:            "Calendar",
 			},
-			ICal: &ICal{
-				SrcCalBase: SrcCalBase{
-					Enabled: true,
+			ICal: []*ICal{
+				{
+					SrcCalBase: SrcCalBase{
+						Enabled: true,
+					},
+					URL:       "https://ics",
+					configDir: testdataDir,
 				},
-				URL: "https://ics",
 			},
 		},
 		Target: Calendars{
-			Google: &Google{
-				SrcCalBase: SrcCalBase{
-					Enabled: true,
+			Google: []*Google{
+				{
+					SrcCalBase: SrcCalBase{
+						Enabled: true,
+					},
+					Id:          "abcd@group.calendar.google.com",
+					Credentials: "credentials.json",
+					Token:       "token.json",
+					configDir:   testdataDir,
 				},
-				Id:          "abcd@group.calendar.google.com",
-				Credentials: "credentials.json",
-				Token:       "token.json",
 			},
 		},
 		Sync: Sync{
 			Days: 14,
 		},
+		location: filepath.Join(testdataDir, "config.toml"),
 	}
 
 	assert.Equal(t, got, expected, "Config should be equal")
 }
+
+func TestGetConfigMultiple(t *testing.T) {
+	got, err := GetConfig("testdata/multiple.toml")
+	if err != nil {
+		t.Fatalf("Failed to get config: %s", err)
+	}
+	testdataDir, _ := filepath.Abs("testdata")
+
+	expected := Calendars{
+		Mac: []*Mac{
+			{
+				SrcCalBase:      SrcCalBase{Enabled: true},
+				ICalBuddyBinary: "/usr/local/bin/icalBuddy",
+				Name:            "Work",
+			},
+		},
+		ICal: []*ICal{
+			{
+				SrcCalBase: SrcCalBase{Enabled: true},
+				Name:       "on-call",
+				URL:        "https://oncall.ics",
+				configDir:  testdataDir,
+			},
+			{
+				SrcCalBase: SrcCalBase{Enabled: true},
+				Name:       "offsites",
+				URL:        "https://offsites.ics",
+				configDir:  testdataDir,
+			},
+		},
+	}
+
+	assert.Equal(t, expected, got.Source, "Sources should be equal")
+	assert.Len(t, got.Target.Google, 2, "Targets should be a list")
+	assert.Equal(t, []*CalDAV{
+		{
+			SrcCalBase:      SrcCalBase{Enabled: true},
+			Name:            "fastmail",
+			URL:             "https://caldav.fastmail.com/dav/calendars/user/me@fastmail.com/work/",
+			Username:        "me@fastmail.com",
+			PasswordCommand: "pass show fastmail/calsync",
+		},
+	}, got.Target.CalDAV, "A single table should be a list too")
+	assert.Equal(t, []Route{
+		{Sources: []string{"on-call"}, Targets: []string{"oncall"}},
+		{Sources: []string{"offsites", "mac"}, Targets: []string{"team"}},
+	}, got.Routes)
+	assert.Equal(t, 7, got.Sync.Days)
+	assert.Equal(t, 30*time.Second, got.Sync.Timeout())
+	maxDeletes, maxPercent, minDeletesForPercent := got.Sync.DeleteLimits()
+	assert.Equal(t, []int{0, 50, 5}, []int{maxDeletes, maxPercent, minDeletesForPercent}, "Delete limits should default")
+
+	interval, cron := got.Daemon.Schedule()
+	assert.Equal(t, time.Duration(0), interval)
+	assert.Equal(t, "*/15 8-18 * * 1-5", cron)
+	assert.Equal(t, time.Minute, got.Daemon.Jitter)
+	assert.Equal(t, Serve{Listen: ":8080", Token: "0123456789abcdef-team", Sources: []string{"offsites", "mac"}, BusyOnly: true}, got.Serve)
+	assert.Equal(t, 15*time.Minute, got.Serve.RefreshInterval())
+
+	// Relative files are resolved from the config file's directory
+	assert.Equal(t, filepath.Join(testdataDir, "token.json"), got.Target.Google[0].TokenFile())
+	assert.Equal(t, "/var/lib/calsync/oncall-token.json", got.Target.Google[1].TokenFile())
+	assert.Equal(t, filepath.Join(testdataDir, "oncall-credentials.json"), got.Target.Google[1].CredentialsFile())
+	if assert.Len(t, got.Target.ICSFile, 1) {
+		assert.Equal(t, filepath.Join(testdataDir, "calendars", "work.ics"), got.Target.ICSFile[0].File())
+	}
+}
+
+func TestDefaultConfigFile(t *testing.T) {
+	t.Setenv("HOME", "/home/user")
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	assert.Equal(t, "/home/user/.config/calsync/config.toml", DefaultConfigFile())
+	assert.Equal(t, "/home/user/.config/calsync/token.json", Google{}.TokenFile())
+
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	assert.Equal(t, "/xdg/calsync/config.toml", DefaultConfigFile())
+	assert.Equal(t, "/xdg/calsync/credentials.json", Google{}.CredentialsFile())
+}
+
+func TestSyncStateFile(t *testing.T) {
+	t.Setenv("HOME", "/home/user")
+	g := Google{Id: "abcd@group.calendar.google.com"}
+
+	t.Setenv("XDG_STATE_HOME", "")
+	assert.Equal(t, "/home/user/.local/state/calsync/gcal-abcd@group.calendar.google.com.json", g.SyncStateFile())
+
+	t.Setenv("XDG_STATE_HOME", "/xdg")
+	assert.Equal(t, "/xdg/calsync/gcal-abcd@group.calendar.google.com.json", g.SyncStateFile())
+}
+
+func TestEventStateFile(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/xdg")
+
+	g := Google{Id: "abcd@group.calendar.google.com"}
+	assert.Equal(t, "/xdg/calsync/gcal-abcd@group.calendar.google.com-events.json", g.EventStateFile())
+
+	g.Namespace = "alice"
+	assert.Equal(t, "/xdg/calsync/gcal-abcd@group.calendar.google.com-alice-events.json", g.EventStateFile())
+}
+
+func TestICalLocation(t *testing.T) {
+	i := ICal{URL: "exports", configDir: "/etc/calsync"}
+	assert.Equal(t, "/etc/calsync/exports", i.Location())
+
+	i.URL = "/home/me/export.ics"
+	assert.Equal(t, "/home/me/export.ics", i.Location())
+
+	i.URL = "webcal://example.com/calendar.ics"
+	assert.Equal(t, "webcal://example.com/calendar.ics", i.Location(), "URLs are used as they are")
+}
@@ -0,0 +1,395 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	minSyncDays = 1
+	maxSyncDays = 365
+)
+
+// Problem is an issue found in the config file, Line is 0 when unknown
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// Validate checks the config file for unknown keys, missing required values
+// and out of range values. All problems are returned, the error is only set
+// when the file can't be read.
+func Validate(location string) ([]Problem, error) {
+	content, err := readConfigFile(location)
+	if err != nil {
+		return nil, err
+	}
+
+	config, md, err := decode(location, content)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return []Problem{{File: location, Line: parseErr.Position.Line, Message: parseErr.Message}}, nil
+		}
+		return []Problem{{File: location, Message: err.Error()}}, nil
+	}
+
+	return validate(location, content, config, md), nil
+}
+
+// validate checks the decoded config, content is used to locate the problems
+func validate(location string, content []byte, config *Config, md toml.MetaData) []Problem {
+	v := &validator{
+		file:    location,
+		locator: newKeyLocator(string(content)),
+		// 'calsync serve' only needs sources
+		serving: md.IsDefined("Serve"),
+	}
+
+	v.checkUndecoded(md.Undecoded())
+	v.checkCalendars("Source", config.Source)
+	v.checkCalendars("Target", config.Target)
+	v.checkRoutes(config)
+	v.checkSync(config.Sync)
+	v.checkDaemon(config.Daemon)
+	v.checkServe(config)
+
+	return v.problems
+}
+
+type validator struct {
+	file     string
+	locator  *keyLocator
+	serving  bool
+	problems []Problem
+}
+
+func (v *validator) add(line int, format string, args ...any) {
+	v.problems = append(v.problems, Problem{File: v.file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkUndecoded reports keys that don't map to any config field, only the
+// top-most key is reported for unknown tables.
+func (v *validator) checkUndecoded(keys []toml.Key) {
+	reported := make([]string, 0)
+	for _, key := range keys {
+		path := strings.Join(key, ".")
+
+		skip := false
+		for _, parent := range reported {
+			if strings.HasPrefix(path, parent+".") {
+				skip = true
+				break
+			}
+		}
+		if skip {
+			continue
+		}
+
+		reported = append(reported, path)
+		v.add(v.locator.keyLine(path), "unknown key %q", path)
+	}
+}
+
+func (v *validator) checkCalendars(kind string, cals Calendars) {
+	enabled := 0
+
+	for i, mac := range cals.Mac {
+		if !mac.Enabled {
+			continue
+		}
+		enabled++
+		line := v.locator.tableLine(kind+".Mac", i)
+		if kind == "Target" {
+			v.add(line, "%s.Mac: Mac calendar can't be used as a target", kind)
+		}
+		if mac.ICalBuddyBinary == "" {
+			v.add(line, "%s.Mac: ICalBuddyBinary is required", kind)
+		}
+		if mac.Name == "" {
+			v.add(line, "%s.Mac: Name of the calendar is required", kind)
+		}
+	}
+
+	for i, ical := range cals.ICal {
+		if !ical.Enabled {
+			continue
+		}
+		enabled++
+		line := v.locator.tableLine(kind+".ICal", i)
+		if kind == "Target" {
+			v.add(line, "%s.ICal: ICS calendar can't be used as a target", kind)
+		}
+		if ical.URL == "" {
+			v.add(line, "%s.ICal: URL is required", kind)
+		} else if strings.Contains(ical.URL, "://") && !icalSchemes[icalScheme(ical.URL)] {
+			v.add(line, "%s.ICal: URL must be an http(s), webcal or file URL, or a path, got %q", kind, ical.URL)
+		}
+	}
+
+	for i, google := range cals.Google {
+		if !google.Enabled {
+			continue
+		}
+		enabled++
+		line := v.locator.tableLine(kind+".Google", i)
+		if kind == "Source" {
+			v.add(line, "%s.Google: Google calendar can't be used as a source", kind)
+		}
+		if google.Id == "" {
+			v.add(line, "%s.Google: Id is required", kind)
+		}
+		if google.AdoptUntagged && google.Namespace == "" {
+			v.add(line, "%s.Google: AdoptUntagged requires a Namespace", kind)
+		}
+	}
+
+	for i, caldav := range cals.CalDAV {
+		if !caldav.Enabled {
+			continue
+		}
+		enabled++
+		line := v.locator.tableLine(kind+".CalDAV", i)
+		if caldav.URL == "" {
+			v.add(line, "%s.CalDAV: URL is required", kind)
+		} else if u, err := url.Parse(caldav.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			v.add(line, "%s.CalDAV: URL must be an http(s) URL, got %q", kind, caldav.URL)
+		}
+		if caldav.Username != "" && caldav.PasswordCommand == "" {
+			v.add(line, "%s.CalDAV: PasswordCommand is required with a Username", kind)
+		}
+	}
+
+	for i, file := range cals.ICSFile {
+		if !file.Enabled {
+			continue
+		}
+		enabled++
+		line := v.locator.tableLine(kind+".ICSFile", i)
+		if kind == "Source" {
+			v.add(line, "%s.ICSFile: ICS file calendar can't be used as a source", kind)
+		}
+		if file.Path == "" {
+			v.add(line, "%s.ICSFile: Path is required", kind)
+		}
+	}
+
+	if enabled == 0 && !(kind == "Target" && v.serving) {
+		v.add(v.locator.tableLine(kind, 0), "no enabled %s calendars", strings.ToLower(kind))
+	}
+}
+
+func (v *validator) checkRoutes(config *Config) {
+	for i, route := range config.Routes {
+		line := v.locator.tableLine("Route", i)
+		if len(route.Sources) == 0 || len(route.Targets) == 0 {
+			v.add(line, "Route: Sources and Targets are required")
+		}
+		for _, name := range route.Sources {
+			if !config.Source.Has(name) {
+				v.add(line, "Route: no enabled source calendar named %q", name)
+			}
+		}
+		for _, name := range route.Targets {
+			if !config.Target.Has(name) {
+				v.add(line, "Route: no enabled target calendar named %q", name)
+			}
+		}
+	}
+}
+
+func (v *validator) checkSync(sync Sync) {
+	if sync.Days < minSyncDays || sync.Days > maxSyncDays {
+		line := v.locator.keyLine("Sync.Days")
+		if line == 0 {
+			line = v.locator.tableLine("Sync", 0)
+		}
+		v.add(line, "Sync.Days must be between %d and %d, got %d", minSyncDays, maxSyncDays, sync.Days)
+	}
+	if sync.MaxDeletes < 0 {
+		v.add(v.locator.keyLine("Sync.MaxDeletes"), "Sync.MaxDeletes can't be negative, got %d", sync.MaxDeletes)
+	}
+	if sync.MaxDeletePercent < 0 || sync.MaxDeletePercent > 100 {
+		v.add(v.locator.keyLine("Sync.MaxDeletePercent"), "Sync.MaxDeletePercent must be between 0 and 100, got %d", sync.MaxDeletePercent)
+	}
+	if sync.MinDeletesForPercent < 0 {
+		v.add(v.locator.keyLine("Sync.MinDeletesForPercent"), "Sync.MinDeletesForPercent can't be negative, got %d", sync.MinDeletesForPercent)
+	}
+	if sync.RequestsPerSecond < 0 {
+		v.add(v.locator.keyLine("Sync.RequestsPerSecond"), "Sync.RequestsPerSecond can't be negative, got %g", sync.RequestsPerSecond)
+	}
+	if sync.SourceTimeout < 0 {
+		v.add(v.locator.keyLine("Sync.SourceTimeout"), "Sync.SourceTimeout can't be negative, got %s", sync.SourceTimeout)
+	}
+	if sync.MaxRetries < 0 {
+		v.add(v.locator.keyLine("Sync.MaxRetries"), "Sync.MaxRetries can't be negative, got %d", sync.MaxRetries)
+	}
+}
+
+func (v *validator) checkDaemon(daemon Daemon) {
+	if daemon.Interval != 0 && daemon.Cron != "" {
+		v.add(v.locator.keyLine("Daemon.Cron"), "Daemon: only one of Interval and Cron can be set")
+	}
+	if daemon.Interval < 0 {
+		v.add(v.locator.keyLine("Daemon.Interval"), "Daemon.Interval can't be negative, got %s", daemon.Interval)
+	}
+	if daemon.Jitter < 0 {
+		v.add(v.locator.keyLine("Daemon.Jitter"), "Daemon.Jitter can't be negative, got %s", daemon.Jitter)
+	}
+	if daemon.Cron != "" {
+		if _, err := cron.ParseStandard(daemon.Cron); err != nil {
+			v.add(v.locator.keyLine("Daemon.Cron"), "Daemon.Cron is invalid: %s", err)
+		}
+	}
+}
+
+// icalSchemes are the URL schemes the ICal source reads
+var icalSchemes = map[string]bool{"http": true, "https": true, "webcal": true, "webcals": true, "file": true}
+
+func icalScheme(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Scheme)
+}
+
+// minServeToken is the shortest Serve.Token accepted, anyone knowing it can read the feed
+const minServeToken = 16
+
+var serveTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (v *validator) checkServe(config *Config) {
+	serve := config.Serve
+	if serve.Token != "" {
+		line := v.locator.keyLine("Serve.Token")
+		if len(serve.Token) < minServeToken {
+			v.add(line, "Serve.Token must be at least %d characters long, got %d", minServeToken, len(serve.Token))
+		}
+		if !serveTokenRe.MatchString(serve.Token) {
+			v.add(line, "Serve.Token can only have letters, digits, '-' and '_'")
+		}
+	}
+	if serve.Interval < 0 {
+		v.add(v.locator.keyLine("Serve.Interval"), "Serve.Interval can't be negative, got %s", serve.Interval)
+	}
+	for _, name := range serve.Sources {
+		if !config.Source.Has(name) {
+			v.add(v.locator.keyLine("Serve.Sources"), "Serve: no enabled source calendar named %q", name)
+		}
+	}
+}
+
+// Has checks if an enabled calendar matches name, either by type (e.g. "ical") or by Name
+func (c Calendars) Has(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, mac := range c.Mac {
+		if mac.Enabled && (name == "mac" || strings.ToLower(mac.Name) == name) {
+			return true
+		}
+	}
+	for _, ical := range c.ICal {
+		if ical.Enabled && (name == "ical" || (ical.Name != "" && strings.ToLower(ical.Name) == name)) {
+			return true
+		}
+	}
+	for _, google := range c.Google {
+		if google.Enabled && (name == "google" || (google.Name != "" && strings.ToLower(google.Name) == name)) {
+			return true
+		}
+	}
+	for _, caldav := range c.CalDAV {
+		if caldav.Enabled && (name == "caldav" || (caldav.Name != "" && strings.ToLower(caldav.Name) == name)) {
+			return true
+		}
+	}
+	for _, file := range c.ICSFile {
+		if file.Enabled && (name == "icsfile" || (file.Name != "" && strings.ToLower(file.Name) == name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	tableHeaderRe = regexp.MustCompile(`^\s*\[\[?\s*([^\]]+?)\s*\]\]?`)
+	keyAssignRe   = regexp.MustCompile(`^\s*([A-Za-z0-9_\-.]+)\s*=`)
+)
+
+// keyLocator finds line numbers of keys and tables, as toml.MetaData doesn't expose them
+type keyLocator struct {
+	// tables maps a table name to the lines it's defined on, once per [[array]] entry
+	tables map[string][]int
+	// keys maps a full key to the first line it's defined on
+	keys map[string]int
+}
+
+func newKeyLocator(content string) *keyLocator {
+	l := &keyLocator{
+		tables: make(map[string][]int),
+		keys:   make(map[string]int),
+	}
+
+	table := ""
+	for i, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if m := tableHeaderRe.FindStringSubmatch(line); m != nil {
+			table = m[1]
+			l.tables[table] = append(l.tables[table], i+1)
+			continue
+		}
+		if m := keyAssignRe.FindStringSubmatch(line); m != nil {
+			key := m[1]
+			if table != "" {
+				key = table + "." + key
+			}
+			if _, ok := l.keys[key]; !ok {
+				l.keys[key] = i + 1
+			}
+		}
+	}
+
+	return l
+}
+
+// keyLine returns the line of a key or a table, 0 when not found
+func (l *keyLocator) keyLine(key string) int {
+	if line, ok := l.keys[key]; ok {
+		return line
+	}
+	return l.tableLine(key, 0)
+}
+
+// tableLine returns the line of the nth definition of a table, 0 when not found.
+// A parent table, e.g. "Source", is found through its first sub-table.
+func (l *keyLocator) tableLine(table string, n int) int {
+	if lines := l.tables[table]; n < len(lines) {
+		return lines[n]
+	}
+
+	first := 0
+	for name, lines := range l.tables {
+		if strings.HasPrefix(name, table+".") && (first == 0 || lines[0] < first) {
+			first = lines[0]
+		}
+	}
+	return first
+}
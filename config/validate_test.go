@@ -0,0 +1,84 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     []Problem
+	}{
+		{
+			name:     "valid config",
+			location: "testdata/config.toml",
+			want:     nil,
+		},
+		{
+			name:     "valid config with lists and routes",
+			location: "testdata/multiple.toml",
+			want:     nil,
+		},
+		{
+			name:     "ICS feeds can be files, only some URL schemes are supported",
+			location: "testdata/locations.toml",
+			want: []Problem{
+				{File: "testdata/locations.toml", Line: 18, Message: `Source.ICal: URL must be an http(s), webcal or file URL, or a path, got "ftp://example.com/calendar.ics"`},
+			},
+		},
+		{
+			name:     "every problem is reported",
+			location: "testdata/invalid.toml",
+			want: []Problem{
+				{File: "testdata/invalid.toml", Line: 3, Message: `unknown key "Source.ICal.Ulr"`},
+				{File: "testdata/invalid.toml", Line: 21, Message: `unknown key "Unknown"`},
+				{File: "testdata/invalid.toml", Line: 1, Message: "Source.ICal: URL is required"},
+				{File: "testdata/invalid.toml", Line: 29, Message: "Source.ICSFile: ICS file calendar can't be used as a source"},
+				{File: "testdata/invalid.toml", Line: 29, Message: "Source.ICSFile: Path is required"},
+				{File: "testdata/invalid.toml", Line: 5, Message: "Target.Google: Id is required"},
+				{File: "testdata/invalid.toml", Line: 5, Message: "Target.Google: AdoptUntagged requires a Namespace"},
+				{File: "testdata/invalid.toml", Line: 24, Message: `Target.CalDAV: URL must be an http(s) URL, got "caldav.example.com/dav/"`},
+				{File: "testdata/invalid.toml", Line: 24, Message: "Target.CalDAV: PasswordCommand is required with a Username"},
+				{File: "testdata/invalid.toml", Line: 10, Message: `Route: no enabled target calendar named "oncall"`},
+				{File: "testdata/invalid.toml", Line: 15, Message: "Sync.Days must be between 1 and 365, got 0"},
+				{File: "testdata/invalid.toml", Line: 16, Message: "Sync.MaxRetries can't be negative, got -1"},
+				{File: "testdata/invalid.toml", Line: 19, Message: "Daemon.Cron is invalid: expected exactly 5 fields, found 3: [every 5 minutes]"},
+				{File: "testdata/invalid.toml", Line: 33, Message: "Serve.Token must be at least 16 characters long, got 7"},
+				{File: "testdata/invalid.toml", Line: 33, Message: "Serve.Token can only have letters, digits, '-' and '_'"},
+				{File: "testdata/invalid.toml", Line: 34, Message: `Serve: no enabled source calendar named "work"`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Validate(tt.location)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	config, problems, err := Load("testdata/locations.toml")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, config.Source.ICal, "The decoded config is returned along with its problems")
+
+	want, err := Validate("testdata/locations.toml")
+	assert.NoError(t, err)
+	assert.Equal(t, want, problems)
+
+	_, _, err = Load("testdata/nonexistent.toml")
+	assert.ErrorIs(t, err, ErrConfigNotFound)
+}
+
+func TestValidateMissingFile(t *testing.T) {
+	_, err := Validate("testdata/nonexistent.toml")
+	assert.Error(t, err)
+
+	_, err = GetConfig("testdata/nonexistent.toml")
+	assert.ErrorIs(t, err, ErrConfigNotFound)
+}
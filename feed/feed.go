@@ -0,0 +1,147 @@
+// Package feed serves events as an iCalendar feed over HTTP, for calendar apps to subscribe to.
+package feed
+
+import (
+	"bytes"
+	"calsync/calendar"
+	"calsync/calendar/ics"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// busyTitle is the title of every event of a busy-only feed
+const busyTitle = "Busy"
+
+// Feed is the latest rendering of the events, served at a path with a secret token.
+// Requests are served while it's updated.
+type Feed struct {
+	path     string
+	busyOnly bool
+
+	mu   sync.RWMutex
+	body []byte
+	etag string
+	// modified is when the body last changed
+	modified time.Time
+	// stamps are the DTSTAMPs of the events by UID, kept as long as they're unchanged so
+	// that an unchanged feed renders the same
+	stamps map[string]stamp
+}
+
+type stamp struct {
+	hash string
+	at   time.Time
+}
+
+// New returns a feed served at /<token>.ics, it's unavailable until the first Update.
+// With busyOnly, only the time of events is published.
+func New(token string, busyOnly bool) *Feed {
+	return &Feed{
+		path:     "/" + token + ".ics",
+		busyOnly: busyOnly,
+		stamps:   make(map[string]stamp),
+	}
+}
+
+// Path returns the path the feed is served at
+func (f *Feed) Path() string {
+	return f.path
+}
+
+// Update renders events as the new feed, now is the DTSTAMP of new and changed events
+func (f *Feed) Update(events []calendar.Event, now time.Time) error {
+	f.mu.RLock()
+	previous := f.stamps
+	f.mu.RUnlock()
+
+	vevents := make([]ics.VEvent, 0, len(events))
+	stamps := make(map[string]stamp, len(events))
+	for _, event := range events {
+		uid := ics.UID(event)
+		// The same event from two sources is published once
+		if _, ok := stamps[uid]; ok {
+			continue
+		}
+		if f.busyOnly {
+			event = busy(event)
+		}
+
+		s, ok := previous[uid]
+		if !ok || s.hash != event.Hash() {
+			s = stamp{hash: event.Hash(), at: now}
+		}
+		stamps[uid] = s
+		vevents = append(vevents, ics.VEvent{UID: uid, Event: event, Stamp: s.at})
+	}
+
+	// Events starting at the same time come in any order, the feed mustn't change because of it
+	sort.Slice(vevents, func(i, j int) bool {
+		if !vevents[i].Event.Start.Equal(vevents[j].Event.Start) {
+			return vevents[i].Event.Start.Before(vevents[j].Event.Start)
+		}
+		return vevents[i].UID < vevents[j].UID
+	})
+
+	var body bytes.Buffer
+	if err := ics.Encode(&body, vevents); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if etag != f.etag {
+		f.modified = now
+	}
+	f.body, f.etag, f.stamps = body.Bytes(), etag, stamps
+
+	return nil
+}
+
+// busy strips event down to its time
+func busy(event calendar.Event) calendar.Event {
+	return calendar.Event{
+		Title:  busyTitle,
+		Start:  event.Start,
+		Stop:   event.Stop,
+		AllDay: event.AllDay,
+		// Only used for the UID, which is a hash of it
+		UID: event.UID,
+	}
+}
+
+// ServeHTTP serves the feed with an ETag, a request with a matching If-None-Match gets a
+// 304 Not Modified. Any other path is not found, so that tokens can't be told apart.
+func (f *Feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Path), []byte(f.path)) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f.mu.RLock()
+	body, etag, modified := f.body, f.etag, f.modified
+	f.mu.RUnlock()
+
+	if body == nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "feed not ready yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	// ServeContent answers If-None-Match with the ETag, and HEAD and Range requests
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
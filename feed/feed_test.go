@@ -0,0 +1,103 @@
+package feed
+
+import (
+	"calsync/calendar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "0123456789abcdef"
+
+func testEvents() []calendar.Event {
+	start := time.Date(2026, 5, 4, 9, 30, 0, 0, time.UTC)
+	return []calendar.Event{
+		{Title: "Dentist", Notes: "Bring the forms", Location: "Main St 1", Start: start, Stop: start.Add(time.Hour), UID: "dentist@example.com"},
+		{Title: "Offsite", Start: time.Date(2026, 5, 6, 0, 0, 0, 0, time.UTC), Stop: time.Date(2026, 5, 8, 0, 0, 0, 0, time.UTC), UID: "offsite@example.com", AllDay: true},
+	}
+}
+
+func get(t *testing.T, f *Feed, method string, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	f.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeHTTP(t *testing.T) {
+	f := New(testToken, false)
+	assert.Equal(t, "/"+testToken+".ics", f.Path())
+
+	rec := get(t, f, http.MethodGet, f.Path(), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "The feed isn't ready before the first update")
+
+	require.NoError(t, f.Update(testEvents(), time.Now()))
+
+	rec = get(t, f, http.MethodGet, f.Path(), nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/calendar; charset=utf-8", rec.Header().Get("Content-Type"))
+	etag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	body := rec.Body.String()
+	assert.Contains(t, body, "SUMMARY:Dentist\r\n")
+	assert.Contains(t, body, "LOCATION:Main St 1\r\n")
+	assert.Contains(t, body, "SUMMARY:Offsite\r\n")
+
+	rec = get(t, f, http.MethodGet, f.Path(), http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	rec = get(t, f, http.MethodGet, f.Path(), http.Header{"If-None-Match": {`"stale"`}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = get(t, f, http.MethodHead, f.Path(), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+
+	rec = get(t, f, http.MethodPost, f.Path(), nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	for _, path := range []string{"/", "/wrong-token.ics", "/" + testToken, "/" + testToken + ".ics/x"} {
+		rec = get(t, f, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+}
+
+func TestUpdateKeepsETag(t *testing.T) {
+	f := New(testToken, false)
+	require.NoError(t, f.Update(testEvents(), time.Now()))
+	etag := get(t, f, http.MethodGet, f.Path(), nil).Header().Get("ETag")
+
+	// Refreshing with the same events later, in another order, renders the same feed
+	events := testEvents()
+	events[0], events[1] = events[1], events[0]
+	require.NoError(t, f.Update(events, time.Now().Add(time.Hour)))
+	assert.Equal(t, etag, get(t, f, http.MethodGet, f.Path(), nil).Header().Get("ETag"))
+
+	events[0].Title = "Offsite (cancelled)"
+	require.NoError(t, f.Update(events, time.Now().Add(2*time.Hour)))
+	assert.NotEqual(t, etag, get(t, f, http.MethodGet, f.Path(), nil).Header().Get("ETag"))
+}
+
+func TestBusyOnly(t *testing.T) {
+	f := New(testToken, true)
+	events := append(testEvents(), testEvents()[0])
+	require.NoError(t, f.Update(events, time.Now()))
+
+	body := get(t, f, http.MethodGet, f.Path(), nil).Body.String()
+	assert.Equal(t, 2, strings.Count(body, "SUMMARY:Busy\r\n"), "Duplicate events must be published once")
+	for _, hidden := range []string{"Dentist", "Bring the forms", "Main St", "Offsite", "example.com"} {
+		assert.NotContains(t, body, hidden)
+	}
+	assert.Contains(t, body, "DTSTART:20260504T093000Z\r\n")
+	assert.Contains(t, body, "DTSTART;VALUE=DATE:20260506\r\n")
+}
@@ -5,10 +5,12 @@ go 1.24.1
 require (
 	github.com/BurntSushi/toml v1.5.0
 	github.com/apognu/gocal v0.9.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/cobra v1.9.1
 	github.com/stretchr/testify v1.10.0
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394
 	golang.org/x/oauth2 v0.28.0
+	golang.org/x/time v0.11.0
 	google.golang.org/api v0.228.0
 )
 
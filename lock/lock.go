@@ -0,0 +1,132 @@
+// Package lock prevents concurrent calsync runs against the same target calendar,
+// which would see each other's new events as stale and delete or duplicate them.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when another running process holds the lock
+var ErrLocked = errors.New("locked by another calsync process")
+
+// errHeld is returned by openLocked when another open file holds the lock
+var errHeld = errors.New("held")
+
+// Lock is a held lock file, containing the PID of its holder
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// Acquire creates and locks the lock file at path. The lock is held on the open file and
+// released by the OS when its holder exits, so a lock file left behind is simply taken over.
+// When another process holds the lock, an error wrapping ErrLocked is returned.
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("creating lock directory: %w", err)
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := openLocked(path)
+		if errors.Is(err, errHeld) {
+			if pid, err := Holder(path); err == nil {
+				return nil, fmt.Errorf("%w: PID %d holds %s", ErrLocked, pid, path)
+			}
+			return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("opening lock file: %w", err)
+		}
+
+		// The previous holder removes the file when releasing it, a file opened just before
+		// that isn't the lock file anymore
+		if !isFile(file, path) {
+			file.Close()
+			continue
+		}
+
+		if err := writePID(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("writing lock file: %w", err)
+		}
+		return &Lock{path: path, file: file}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s keeps being replaced", ErrLocked, path)
+}
+
+// isFile checks that file is still the one at path
+func isFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
+
+// writePID replaces the content of file, which may be from a previous holder, with the PID
+func writePID(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return err
+}
+
+// Wait acquires the lock, waiting for its holder to release it until ctx is done
+func Wait(ctx context.Context, path string, poll time.Duration) (*Lock, error) {
+	for {
+		lock, err := Acquire(path)
+		if !errors.Is(err, ErrLocked) {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", err, ctx.Err())
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Holder returns the PID written in the lock file at path
+func Holder(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid lock file %s: %w", path, err)
+	}
+
+	return pid, nil
+}
+
+// Release removes the lock file and releases the lock. It's removed while still locked, so
+// that no one locks it in between.
+func (l *Lock) Release() error {
+	removeErr := os.Remove(l.path)
+	if errors.Is(removeErr, os.ErrNotExist) {
+		removeErr = nil
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("releasing lock file: %w", err)
+	}
+	if removeErr != nil {
+		return fmt.Errorf("removing lock file: %w", removeErr)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "target.lock")
+
+	lock, err := Acquire(path)
+	require.NoError(t, err)
+
+	pid, err := Holder(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+
+	_, err = Acquire(path)
+	assert.True(t, errors.Is(err, ErrLocked), "second Acquire should be locked, got %v", err)
+
+	require.NoError(t, lock.Release())
+	_, err = os.Stat(path)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "lock file should be removed")
+
+	lock, err = Acquire(path)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
+
+func TestAcquireStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.lock")
+
+	// A process that already exited
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	require.NoError(t, cmd.Run())
+	require.NoError(t, os.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)), 0600))
+
+	lock, err := Acquire(path)
+	require.NoError(t, err)
+	defer lock.Release()
+
+	pid, err := Holder(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+}
+
+func TestAcquireStaleConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.lock")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	require.NoError(t, cmd.Run())
+	require.NoError(t, os.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)), 0600))
+
+	const contenders = 8
+	start := make(chan struct{})
+	results := make(chan error, contenders)
+	var wg sync.WaitGroup
+	for i := 0; i < contenders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := Acquire(path)
+			results <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	acquired := 0
+	for err := range results {
+		if err == nil {
+			acquired++
+		} else {
+			assert.True(t, errors.Is(err, ErrLocked), "losers should be locked out, got %v", err)
+		}
+	}
+	assert.Equal(t, 1, acquired, "exactly one contender must take over the stale lock")
+}
+
+func TestAcquireConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.lock")
+
+	// Holders acquiring and releasing in a loop must never overlap
+	var holders atomic.Int32
+	var overlapped atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				lock, err := Acquire(path)
+				if errors.Is(err, ErrLocked) {
+					continue
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+				if holders.Add(1) > 1 {
+					overlapped.Store(true)
+				}
+				time.Sleep(time.Millisecond)
+				holders.Add(-1)
+				assert.NoError(t, lock.Release())
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.False(t, overlapped.Load(), "two holders held the lock at once")
+}
+
+func TestWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.lock")
+
+	held, err := Acquire(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Wait(ctx, path, 10*time.Millisecond)
+	assert.True(t, errors.Is(err, ErrLocked), "Wait should time out while locked, got %v", err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "Wait should time out while locked, got %v", err)
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		held.Release()
+	}()
+
+	lock, err := Wait(context.Background(), path, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
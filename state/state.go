@@ -0,0 +1,136 @@
+// Package state remembers, per target calendar, which target event each source event was
+// synced to, so that later runs don't have to rediscover it from the events' content.
+package state
+
+import (
+	"calsync/calendar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Entry is what was last synced for a source event
+type Entry struct {
+	// TargetID is the ID of the event at the target calendar
+	TargetID string
+	// Hash is the source event's hash when it was last written to the target
+	Hash string
+	// LastSeen is when the event was last seen at the source
+	LastSeen time.Time
+}
+
+// Store is the state of a single target calendar, kept in a JSON file
+type Store struct {
+	path string
+	// Entries are keyed by Key of the source event
+	Entries map[string]Entry
+}
+
+// New returns an empty store, saved to path
+func New(path string) *Store {
+	return &Store{
+		path:    path,
+		Entries: make(map[string]Entry),
+	}
+}
+
+// Open reads the store saved at path, a missing file is an empty store
+func Open(path string) (*Store, error) {
+	s := New(path)
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state: %w", err)
+	}
+
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("decoding state %s: %w", path, err)
+	}
+	if s.Entries == nil {
+		s.Entries = make(map[string]Entry)
+	}
+
+	return s, nil
+}
+
+// Key identifies a source event across runs: its UID, and its RecurrenceID as instances of
+// recurring events share the UID. Unlike the start, both stay the same when the event is
+// moved. Events without UID can't be tracked, Key is empty.
+func Key(event calendar.Event) string {
+	if event.UID == "" {
+		return ""
+	}
+	if event.RecurrenceID.IsZero() {
+		return event.UID
+	}
+	return event.UID + "@" + event.RecurrenceID.UTC().Format(time.RFC3339)
+}
+
+// Get returns the entry of the source event with key
+func (s *Store) Get(key string) (Entry, bool) {
+	if key == "" {
+		return Entry{}, false
+	}
+	entry, ok := s.Entries[key]
+	return entry, ok
+}
+
+// Put records that the source event with key was synced as entry
+func (s *Store) Put(key string, entry Entry) {
+	if key == "" {
+		return
+	}
+	s.Entries[key] = entry
+}
+
+// DeleteTarget forgets the entries synced to the target event with targetID
+func (s *Store) DeleteTarget(targetID string) {
+	for key, entry := range s.Entries {
+		if entry.TargetID == targetID {
+			delete(s.Entries, key)
+		}
+	}
+}
+
+// Prune forgets entries not seen at the source since before, returning how many
+func (s *Store) Prune(before time.Time) int {
+	n := 0
+	for key, entry := range s.Entries {
+		if entry.LastSeen.Before(before) {
+			delete(s.Entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// Save writes the store with WriteJSON
+func (s *Store) Save() error {
+	return WriteJSON(s.path, s)
+}
+
+// WriteJSON writes v as JSON to a temporary file first, so that an interrupted
+// run never leaves a truncated state behind
+func WriteJSON(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
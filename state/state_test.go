@@ -0,0 +1,82 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"calsync/calendar"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "gcal-test.json")
+
+	s, err := Open(path)
+	require.NoError(t, err)
+	assert.Empty(t, s.Entries, "missing file is an empty store")
+
+	seen := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	s.Put("uid1@2026-05-01T10:00:00Z", Entry{TargetID: "event1", Hash: "hash1", LastSeen: seen})
+	s.Put("", Entry{TargetID: "untracked"})
+	require.NoError(t, s.Save())
+
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err), "temporary file should be renamed")
+
+	s, err = Open(path)
+	require.NoError(t, err)
+	assert.Len(t, s.Entries, 1)
+	entry, ok := s.Get("uid1@2026-05-01T10:00:00Z")
+	require.True(t, ok)
+	assert.Equal(t, "event1", entry.TargetID)
+	assert.Equal(t, "hash1", entry.Hash)
+	assert.True(t, seen.Equal(entry.LastSeen))
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gcal-test.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+
+	_, err := Open(path)
+	assert.Error(t, err)
+}
+
+func TestKey(t *testing.T) {
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	assert.Equal(t, "uid1", Key(calendar.Event{UID: "uid1", Start: start}))
+	assert.Equal(t, Key(calendar.Event{UID: "uid1", Start: start}), Key(calendar.Event{UID: "uid1", Start: start.Add(time.Hour)}),
+		"moved events keep their key")
+
+	instance := calendar.Event{UID: "uid1", Start: start, RecurrenceID: start}
+	assert.Equal(t, "uid1@2026-05-01T10:00:00Z", Key(instance))
+	next := calendar.Event{UID: "uid1", Start: start.AddDate(0, 0, 7), RecurrenceID: start.AddDate(0, 0, 7)}
+	assert.NotEqual(t, Key(instance), Key(next), "instances of recurring events need their own key")
+	moved := instance
+	moved.Start = start.Add(time.Hour)
+	assert.Equal(t, Key(instance), Key(moved), "moved instances keep their key")
+
+	assert.Empty(t, Key(calendar.Event{Start: start}))
+}
+
+func TestDeleteTargetPrune(t *testing.T) {
+	now := time.Now()
+	s := New(filepath.Join(t.TempDir(), "gcal-test.json"))
+	s.Put("a", Entry{TargetID: "event1", LastSeen: now})
+	s.Put("b", Entry{TargetID: "event2", LastSeen: now})
+	s.Put("c", Entry{TargetID: "event3", LastSeen: now.AddDate(0, 0, -40)})
+
+	s.DeleteTarget("event1")
+	_, ok := s.Get("a")
+	assert.False(t, ok)
+
+	assert.Equal(t, 1, s.Prune(now.AddDate(0, 0, -30)))
+	_, ok = s.Get("b")
+	assert.True(t, ok)
+	_, ok = s.Get("c")
+	assert.False(t, ok)
+}